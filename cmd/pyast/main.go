@@ -0,0 +1,669 @@
+// Command pyast answers questions about the import graph of python projects.
+//
+//	pyast rdeps|deps|affected|roots|export|external|requirements|serve [flags] [path ...]
+//
+// Paths are read from the arguments, or from stdin (newline or NUL
+// separated) if there are none. The affected command can instead select
+// tests for the python files changed in a git revision range, with --git.
+//
+// "pyast export" writes the import graph of the --root directories, or the
+// part of it around the given paths, as DOT, GraphML, Mermaid or JSON.
+//
+// "pyast external" lists the standard library and third-party modules which
+// each module, or with --by-root each root, imports.
+//
+// "pyast requirements --site-packages DIR" reports, for each root, the
+// installed distributions it imports without declaring them in
+// pyproject.toml, and the declared ones it never imports.
+//
+// "pyast serve --socket PATH" keeps the graph in memory, updating it as
+// files change; other commands use it when given "--server PATH".
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	file "github.com/nicois/file"
+	"github.com/nicois/pyast"
+	log "github.com/sirupsen/logrus"
+)
+
+const usage = `usage: pyast <command> [flags] [path ...]
+
+commands:
+  rdeps     files which import the given files, directly or indirectly
+  deps      files imported by the given files, directly or indirectly
+  affected  test files which import the given files (or the files changed
+            in the --git revision range), directly or indirectly
+  roots     the python roots containing the given files
+  export    the import graph, or the part of it around the given files
+  external  the stdlib and third-party modules imported by each file, or root
+  requirements
+            distributions which each root imports without declaring them in
+            pyproject.toml, and declared ones which it does not import
+  serve     keep the graph up to date in memory, answering queries on --socket
+
+Run "pyast <command> -h" for the flags of each command.
+`
+
+// listFlag collects repeated flags.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+type options struct {
+	roots             listFlag
+	namespacePackages bool
+	failFast          bool
+	stringRefs        bool
+	pytest            bool
+	format            string
+	depth             int
+	gitRange          string
+	socket            string
+	testPatterns      listFlag
+	ignore            listFlag
+	verbose           bool
+	config            string
+	noConfig          bool
+	exclude           listFlag
+	noGitignore       bool
+	canonical         bool
+	snapshot          string
+	cache             string
+	symbols           listFlag
+	indexSymbols      bool
+	pythonVersion     string
+	byRoot            bool
+	sitePackages      listFlag
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	// reporting is disabled unless SENTRY_DSN is set
+	if err := sentry.Init(sentry.ClientOptions{}); err != nil {
+		log.Warnf("Not reporting to sentry: %v", err)
+	}
+	err := run(context.Background(), os.Args[1], os.Args[2:], os.Stdin, os.Stdout)
+	sentry.Flush(2 * time.Second)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, command string, args []string, stdin io.Reader, stdout io.Writer) error {
+	var opts options
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.Var(&opts.roots, "root", "a python root to scan (repeatable). Defaults to the roots containing the given paths")
+	flags.BoolVar(&opts.namespacePackages, "namespace-packages", false, "descend into directories without __init__.py")
+	flags.Var(&opts.exclude, "exclude", "a pattern, in .gitignore syntax, of files and directories not to scan (repeatable)")
+	flags.BoolVar(&opts.noGitignore, "no-gitignore", false, "scan files even if git ignores them")
+	flags.StringVar(&opts.cache, "cache", "", `where to cache the imports of each file: a directory, "memory", "none" or "default" (an sqlite database in ~/.cache)`)
+	flags.BoolVar(&opts.failFast, "fail-fast", false, "exit if any file cannot be processed, instead of ignoring it")
+	flags.BoolVar(&opts.pytest, "pytest", false, "make test modules depend on their conftest.py files, and follow pytest_plugins")
+	flags.BoolVar(&opts.stringRefs, "string-refs", false, "treat dotted paths in mock.patch targets, Django settings and celery task names as imports")
+	flags.StringVar(&opts.pythonVersion, "python-version", "", fmt.Sprintf("the python version whose standard library is recognised. Defaults to %v", pyast.DefaultPythonVersion))
+	if command == "export" {
+		flags.StringVar(&opts.format, "format", "json", "output format: dot, graphml, mermaid or json")
+	} else {
+		flags.StringVar(&opts.format, "format", "lines", "output format: lines, json or nul")
+	}
+	flags.BoolVar(&opts.verbose, "verbose", false, "enable debug logging")
+	flags.StringVar(&opts.config, "config", "", "read settings from this file. Defaults to the nearest .pyast.toml or pyproject.toml with a [tool.pyast] table")
+	flags.BoolVar(&opts.noConfig, "no-config", false, "do not look for a config file")
+	switch command {
+	case "rdeps", "deps", "affected":
+		flags.StringVar(&opts.socket, "server", "", "query the server listening on this unix socket, instead of scanning")
+	case "serve":
+		flags.StringVar(&opts.socket, "socket", "", "the unix socket to listen on")
+	}
+	switch command {
+	case "rdeps", "deps", "affected", "external", "requirements":
+		flags.Var(&opts.ignore, "ignore", "an import kind not to follow (repeatable): type-checking, function-local, fallback, version-conditional, dynamic, string-ref or implicit")
+	}
+	switch command {
+	case "rdeps", "deps", "affected":
+		flags.BoolVar(&opts.canonical, "canonical", false, "resolve symlinks in the paths written, rather than using the paths they were reached through")
+	}
+	switch command {
+	case "rdeps", "affected":
+		flags.Var(&opts.symbols, "symbol", "a changed definition, as PATH:NAME (repeatable), to follow instead of whole files")
+	}
+	switch command {
+	case "affected":
+		flags.BoolVar(&opts.indexSymbols, "symbols", false, "with --git, only follow the definitions which changed in each modified file")
+	case "external":
+		flags.BoolVar(&opts.byRoot, "by-root", false, "combine the modules of each root, rather than listing each file")
+	case "requirements":
+		flags.Var(&opts.sitePackages, "site-packages", "a site-packages directory whose distributions provide the third-party modules (repeatable, required)")
+	case "serve":
+		flags.BoolVar(&opts.indexSymbols, "symbols", false, "record the names each definition refers to, so that queries may give symbols")
+	}
+	switch command {
+	case "rdeps", "deps", "affected", "export", "external", "requirements":
+		flags.StringVar(&opts.snapshot, "snapshot", "", "load the graph from this file, rescanning only the files which changed, and save it back")
+	}
+	switch command {
+	case "deps":
+		flags.IntVar(&opts.depth, "depth", 0, "how many levels of imports to follow (0 means unlimited)")
+	case "export":
+		flags.IntVar(&opts.depth, "depth", 0, "how many levels of imports to follow, in either direction, from the given paths (0 means unlimited)")
+	case "affected":
+		flags.StringVar(&opts.gitRange, "git", "", "select tests for the python files changed in this git revision range (eg main...HEAD)")
+		flags.Var(&opts.testPatterns, "test-pattern", fmt.Sprintf("a glob matching test file names (repeatable). Defaults to %v", strings.Join(pyast.DefaultTestPatterns, " and ")))
+	}
+	switch command {
+	case "rdeps", "deps", "affected", "roots", "export", "external", "requirements", "serve":
+	case "-h", "--help", "help":
+		fmt.Fprint(stdout, usage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n%v", command, usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if opts.verbose {
+		log.SetLevel(log.DebugLevel)
+	}
+	var buildOptions pyast.BuildTreesOptions
+	config, err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+	if config != nil {
+		// flags take precedence over the config
+		log.Debugf("Using settings from %v", config.Path)
+		buildOptions = config.BuildTreesOptions()
+		if len(opts.roots) == 0 {
+			for root := range config.RootPaths() {
+				opts.roots = append(opts.roots, root)
+			}
+		}
+		if len(opts.testPatterns) == 0 {
+			opts.testPatterns = config.TestPatterns
+		}
+		if ignore := config.QueryOptions().Ignore; len(opts.ignore) == 0 && ignore != pyast.ImportRuntime {
+			opts.ignore = listFlag{ignore.String()}
+		}
+	}
+	buildOptions.NamespacePackages = buildOptions.NamespacePackages || opts.namespacePackages
+	buildOptions.FailFast = opts.failFast
+	buildOptions.NoGitignore = buildOptions.NoGitignore || opts.noGitignore
+	buildOptions.Symbols = buildOptions.Symbols || opts.indexSymbols || len(opts.symbols) > 0
+	if opts.cache != "" {
+		buildOptions.Cache = pyast.ParseImportCache(opts.cache)
+	}
+	if opts.pythonVersion != "" {
+		if _, err := pyast.ParsePythonVersion(opts.pythonVersion); err != nil {
+			return err
+		}
+		buildOptions.PythonVersion = opts.pythonVersion
+	}
+	for _, pattern := range opts.exclude {
+		if trimmed := strings.TrimRight(pattern, "/"); strings.Contains(trimmed, "/") && !strings.HasPrefix(trimmed, "/") && !strings.HasPrefix(trimmed, "!") {
+			// relative to the working directory
+			if absolute, err := filepath.Abs(trimmed); err == nil {
+				pattern = absolute + pattern[len(trimmed):]
+			}
+		}
+		buildOptions.Exclude = append(buildOptions.Exclude, pattern)
+	}
+	if opts.stringRefs {
+		refs := pyast.DefaultStringReferences
+		buildOptions.StringReferences = &refs
+	}
+	if opts.pytest {
+		buildOptions.Pytest = &pyast.PytestOptions{TestPatterns: opts.testPatterns}
+	}
+	if command == "export" {
+		return export(ctx, stdout, opts, buildOptions, flags.Args())
+	}
+	switch opts.format {
+	case "lines", "json", "nul":
+	default:
+		return fmt.Errorf("unknown format %q", opts.format)
+	}
+
+	var ignore pyast.ImportKind
+	for _, name := range opts.ignore {
+		kind, err := pyast.ParseImportKind(name)
+		if err != nil {
+			return err
+		}
+		ignore |= kind
+	}
+	queryOptions := pyast.QueryOptions{MaxDepth: opts.depth, Ignore: ignore, Canonical: opts.canonical}
+	switch command {
+	case "external":
+		return external(ctx, stdout, opts, buildOptions, ignore, flags.Args())
+	case "requirements":
+		return requirements(ctx, stdout, opts, buildOptions, ignore, flags.Args())
+	}
+	roots := file.CreatePaths(opts.roots...)
+	if command == "serve" {
+		return serve(ctx, roots, buildOptions, opts.socket)
+	}
+	if command == "affected" && opts.gitRange != "" {
+		if flags.NArg() > 0 || len(opts.symbols) > 0 {
+			return fmt.Errorf("paths cannot be given with --git")
+		}
+		if opts.socket != "" {
+			changed, err := pyast.ChangedFiles(ctx, ".", opts.gitRange)
+			if err != nil {
+				return err
+			}
+			var symbols []pyast.Symbol
+			if opts.indexSymbols {
+				definitions, err := pyast.ChangedSymbols(ctx, ".", opts.gitRange)
+				if err != nil {
+					return err
+				}
+				for path, changes := range definitions {
+					delete(changed, path)
+					for _, change := range changes {
+						symbols = append(symbols, change.Symbol(path))
+					}
+				}
+			}
+			return query(ctx, stdout, opts, command, changed, symbols)
+		}
+		result, err := pyast.AffectedTests(ctx, ".", opts.gitRange, pyast.AffectedOptions{
+			BuildTreesOptions: buildOptions,
+			Roots:             roots,
+			TestPatterns:      opts.testPatterns,
+			Ignore:            ignore,
+			Canonical:         opts.canonical,
+			Snapshot:          opts.snapshot,
+		})
+		if err != nil {
+			if result == nil {
+				return err
+			}
+			warnBuildError(err)
+		}
+		return writePaths(stdout, result, opts.format)
+	}
+
+	var symbols []pyast.Symbol
+	var paths file.Paths
+	if len(opts.symbols) > 0 {
+		if flags.NArg() > 0 {
+			return fmt.Errorf("paths cannot be given with --symbol")
+		}
+		if symbols, err = parseSymbols(opts.symbols); err != nil {
+			return err
+		}
+		paths = file.CreatePaths()
+		for _, symbol := range symbols {
+			paths.Add(symbol.Path)
+		}
+	} else if paths, err = readPaths(flags.Args(), stdin); err != nil {
+		return err
+	}
+	if opts.socket != "" {
+		if symbols != nil {
+			return query(ctx, stdout, opts, command, nil, symbols)
+		}
+		return query(ctx, stdout, opts, command, paths, nil)
+	}
+	if len(roots) == 0 {
+		if roots, err = pyast.CalculatePythonRoots(paths); err != nil {
+			return err
+		}
+	}
+	if command == "roots" {
+		return writePaths(stdout, roots, opts.format)
+	}
+
+	trees, err := pyast.BuildTreesWithSnapshot(ctx, opts.snapshot, roots, buildOptions)
+	if err != nil {
+		if trees == nil {
+			return err
+		}
+		warnBuildError(err)
+	}
+
+	dependees := func() (file.Paths, error) {
+		if symbols != nil {
+			return trees.GetDependeesOfSymbols(symbols, queryOptions)
+		}
+		return trees.GetDependeesWithOptions(paths, queryOptions)
+	}
+	var result file.Paths
+	switch command {
+	case "rdeps":
+		result, err = dependees()
+	case "deps":
+		result, err = trees.GetDependencies(paths, queryOptions)
+	case "affected":
+		if result, err = dependees(); err == nil {
+			patterns := opts.testPatterns
+			if len(patterns) == 0 {
+				patterns = pyast.DefaultTestPatterns
+			}
+			result = pyast.FilterTests(result, patterns)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return writePaths(stdout, result, opts.format)
+}
+
+// loadConfig reads the --config file, or the one found above the working
+// directory. It returns nil if there is none.
+func loadConfig(opts options) (*pyast.Config, error) {
+	if opts.noConfig {
+		return nil, nil
+	}
+	if opts.config != "" {
+		config, err := pyast.LoadConfig(opts.config)
+		if err == nil && config == nil {
+			err = fmt.Errorf("%v has no [tool.pyast] table", opts.config)
+		}
+		return config, err
+	}
+	return pyast.FindConfig(".")
+}
+
+// export writes the graph of the roots, or of the roots containing paths,
+// restricted to the neighbourhood of paths if there are any.
+func export(ctx context.Context, stdout io.Writer, opts options, buildOptions pyast.BuildTreesOptions, args []string) error {
+	format := pyast.ExportFormat(opts.format)
+	known := false
+	for _, f := range pyast.ExportFormats {
+		known = known || f == format
+	}
+	if !known {
+		return fmt.Errorf("unknown format %q", opts.format)
+	}
+	paths := file.CreatePaths(args...)
+	roots := file.CreatePaths(opts.roots...)
+	if len(roots) == 0 {
+		if len(paths) == 0 {
+			return fmt.Errorf("at least one --root or path is required")
+		}
+		var err error
+		if roots, err = pyast.CalculatePythonRoots(paths); err != nil {
+			return err
+		}
+	}
+	trees, err := pyast.BuildTreesWithSnapshot(ctx, opts.snapshot, roots, buildOptions)
+	if err != nil {
+		if trees == nil {
+			return err
+		}
+		warnBuildError(err)
+	}
+	return trees.Export(stdout, format, pyast.ExportOptions{Around: paths, Depth: opts.depth})
+}
+
+// requirements writes the dependency report of each root, returning an
+// error if any distributions are undeclared or unused.
+func requirements(ctx context.Context, stdout io.Writer, opts options, buildOptions pyast.BuildTreesOptions, ignore pyast.ImportKind, args []string) error {
+	if opts.format == "nul" {
+		return fmt.Errorf("unknown format %q", opts.format)
+	}
+	if len(opts.sitePackages) == 0 {
+		return fmt.Errorf("at least one --site-packages is required")
+	}
+	installed, err := pyast.ScanSitePackages(opts.sitePackages...)
+	if err != nil {
+		return err
+	}
+	paths := file.CreatePaths(args...)
+	roots := file.CreatePaths(opts.roots...)
+	if len(roots) == 0 {
+		if len(paths) == 0 {
+			return fmt.Errorf("at least one --root or path is required")
+		}
+		if roots, err = pyast.CalculatePythonRoots(paths); err != nil {
+			return err
+		}
+	}
+	trees, err := pyast.BuildTreesWithSnapshot(ctx, opts.snapshot, roots, buildOptions)
+	if err != nil {
+		if trees == nil {
+			return err
+		}
+		warnBuildError(err)
+	}
+	reports, err := trees.CheckDependencies(installed, ignore)
+	if err != nil {
+		return err
+	}
+	if opts.format == "json" {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(reports); err != nil {
+			return err
+		}
+	}
+	sorted := make([]string, 0, len(reports))
+	for root := range reports {
+		sorted = append(sorted, root)
+	}
+	sort.Strings(sorted)
+	problems := 0
+	for _, root := range sorted {
+		report := reports[root]
+		problems += len(report.Undeclared) + len(report.Unused)
+		if opts.format == "json" {
+			continue
+		}
+		for _, section := range []struct {
+			name  string
+			names []string
+		}{{"undeclared", report.Undeclared}, {"unused", report.Unused}, {"unknown", report.Unknown}} {
+			for _, name := range section.names {
+				if _, err := fmt.Fprintf(stdout, "%v\t%v\t%v\n", root, section.name, name); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if problems > 0 {
+		return fmt.Errorf("found %v undeclared or unused dependencies", problems)
+	}
+	return nil
+}
+
+// external writes the stdlib and third-party modules imported by each of
+// the given paths, or every module, in the roots containing them.
+func external(ctx context.Context, stdout io.Writer, opts options, buildOptions pyast.BuildTreesOptions, ignore pyast.ImportKind, args []string) error {
+	if opts.format == "nul" {
+		return fmt.Errorf("unknown format %q", opts.format)
+	}
+	paths := file.CreatePaths(args...)
+	roots := file.CreatePaths(opts.roots...)
+	if len(roots) == 0 {
+		if len(paths) == 0 {
+			return fmt.Errorf("at least one --root or path is required")
+		}
+		var err error
+		if roots, err = pyast.CalculatePythonRoots(paths); err != nil {
+			return err
+		}
+	}
+	trees, err := pyast.BuildTreesWithSnapshot(ctx, opts.snapshot, roots, buildOptions)
+	if err != nil {
+		if trees == nil {
+			return err
+		}
+		warnBuildError(err)
+	}
+	var report map[string]pyast.ExternalDependencies
+	if opts.byRoot {
+		report = trees.ExternalDependenciesByRoot(ignore)
+	} else {
+		report = trees.ExternalDependencies(ignore)
+		if len(paths) > 0 {
+			for path := range report {
+				if _, ok := paths[path]; !ok {
+					delete(report, path)
+				}
+			}
+		}
+	}
+	if opts.format == "json" {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	keys := make([]string, 0, len(report))
+	for key := range report {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, module := range report[key].Stdlib {
+			if _, err := fmt.Fprintf(stdout, "%v\t%v\t%v\n", key, pyast.OriginStdlib, module); err != nil {
+				return err
+			}
+		}
+		for _, module := range report[key].ThirdParty {
+			if _, err := fmt.Fprintf(stdout, "%v\t%v\t%v\n", key, pyast.OriginThirdParty, module); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// warnBuildError logs the files which could not be processed, which are
+// left out of the graph, reporting them to sentry as a single event.
+func warnBuildError(err error) {
+	log.Warn(err)
+	sentry.CaptureException(err)
+}
+
+func serve(ctx context.Context, roots file.Paths, buildOptions pyast.BuildTreesOptions, socket string) error {
+	if socket == "" {
+		return fmt.Errorf("--socket is required")
+	}
+	if len(roots) == 0 {
+		return fmt.Errorf("at least one --root is required")
+	}
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server, err := pyast.NewServer(ctx, roots, buildOptions)
+	if err != nil {
+		if server == nil {
+			return err
+		}
+		warnBuildError(err)
+	}
+	log.Infof("Listening on %v", socket)
+	return server.ListenAndServe(ctx, socket)
+}
+
+func query(ctx context.Context, stdout io.Writer, opts options, command string, paths file.Paths, symbols []pyast.Symbol) error {
+	request := pyast.Request{Command: command, Depth: opts.depth, TestPatterns: opts.testPatterns, Ignore: opts.ignore, Canonical: opts.canonical, Symbols: symbols}
+	for path := range paths {
+		request.Paths = append(request.Paths, path)
+	}
+	response, err := pyast.QueryServer(ctx, opts.socket, request)
+	if err != nil {
+		return err
+	}
+	return writePaths(stdout, file.CreatePaths(response.Paths...), opts.format)
+}
+
+// parseSymbols interprets PATH:NAME values, making each path absolute.
+func parseSymbols(values []string) ([]pyast.Symbol, error) {
+	var result []pyast.Symbol
+	for _, value := range values {
+		i := strings.LastIndex(value, ":")
+		if i <= 0 || i == len(value)-1 {
+			return nil, fmt.Errorf("%q is not of the form PATH:NAME", value)
+		}
+		path, err := filepath.Abs(value[:i])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, pyast.Symbol{Path: path, Name: value[i+1:]})
+	}
+	return result, nil
+}
+
+// readPaths uses the arguments if there are any, otherwise it reads
+// newline- or NUL-separated paths from stdin.
+func readPaths(args []string, stdin io.Reader) (file.Paths, error) {
+	if len(args) > 0 {
+		return file.CreatePaths(args...), nil
+	}
+	result := file.CreatePaths()
+	scanner := bufio.NewScanner(stdin)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexAny(data, "\n\x00"); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+	for scanner.Scan() {
+		if path := strings.TrimSpace(scanner.Text()); path != "" {
+			result.Union(file.CreatePaths(path))
+		}
+	}
+	return result, scanner.Err()
+}
+
+func writePaths(w io.Writer, paths file.Paths, format string) error {
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(sorted)
+	case "nul":
+		for _, path := range sorted {
+			if _, err := fmt.Fprintf(w, "%v\x00", path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, path := range sorted {
+		if _, err := fmt.Fprintln(w, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunDeps(t *testing.T) {
+	root, _ := filepath.Abs("../../testdata/forward/src")
+	var stdout bytes.Buffer
+	stdin := strings.NewReader(filepath.Join(root, "app/a.py") + "\n")
+	if err := run(context.Background(), "deps", []string{"--depth", "1", "--format", "nul"}, stdin, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	expected := filepath.Join(root, "app/__init__.py") + "\x00" + filepath.Join(root, "app/b.py") + "\x00"
+	if stdout.String() != expected {
+		t.Fatalf("Found unexpected output:\n%q", stdout.String())
+	}
+}
+
+func TestRunRoots(t *testing.T) {
+	root, _ := filepath.Abs("../../testdata/forward/src")
+	var stdout bytes.Buffer
+	if err := run(context.Background(), "roots", []string{"--format", "json", filepath.Join(root, "app/b.py")}, nil, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[\n  \"" + root + "\"\n]\n"; stdout.String() != expected {
+		t.Fatalf("Found unexpected output:\n%q", stdout.String())
+	}
+}
+
+func TestRunExport(t *testing.T) {
+	root, _ := filepath.Abs("../../testdata/forward/src")
+	var stdout bytes.Buffer
+	if err := run(context.Background(), "export", []string{"--format", "mermaid", "--depth", "1", filepath.Join(root, "app/c.py")}, nil, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	expected := "flowchart LR\n    n0[\"app.b\"]\n    n1[\"app.c\"]\n    n0 -->|module| n1\n"
+	if stdout.String() != expected {
+		t.Fatalf("Found unexpected output:\n%q", stdout.String())
+	}
+}
+
+func TestRunExternal(t *testing.T) {
+	root, _ := filepath.Abs("../../testdata/forward/src")
+	var stdout bytes.Buffer
+	if err := run(context.Background(), "external", []string{"--no-config", "--cache", "none", "--by-root", "--root", root}, nil, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	if expected := root + "\tstdlib\tos\n"; stdout.String() != expected {
+		t.Fatalf("Found unexpected output:\n%q", stdout.String())
+	}
+}
+
+func TestRunRequirements(t *testing.T) {
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+	for name, content := range map[string]string{
+		"pyproject.toml":      "[project]\nname = \"app\"\ndependencies = [\"requests\", \"flask\"]\n",
+		"src/app/__init__.py": "import requests\nimport yaml\n",
+		"site-packages/requests-2.31.0.dist-info/top_level.txt": "requests\n",
+		"site-packages/PyYAML-6.0.dist-info/top_level.txt":      "yaml\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root := filepath.Join(dir, "src")
+	var stdout bytes.Buffer
+	err := run(context.Background(), "requirements", []string{"--no-config", "--cache", "none", "--site-packages", filepath.Join(dir, "site-packages"), "--root", root}, nil, &stdout)
+	if err == nil {
+		t.Fatal("expected an error for the undeclared and unused dependencies")
+	}
+	if expected := root + "\tundeclared\tpyyaml\n" + root + "\tunused\tflask\n"; stdout.String() != expected {
+		t.Fatalf("Found unexpected output:\n%q", stdout.String())
+	}
+}
@@ -0,0 +1,229 @@
+package pyast
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	file "github.com/nicois/file"
+)
+
+// ConfigFiles are the files FindConfig looks for in each directory, in
+// order of preference. pyproject.toml is only used if it has a [tool.pyast] table.
+var ConfigFiles = []string{".pyast.toml", "pyproject.toml"}
+
+// Config is read from the [tool.pyast] table of pyproject.toml, or the top
+// level of .pyast.toml. Paths are relative to the directory containing the file.
+//
+//	[tool.pyast]
+//	roots = ["src", "tests"]
+//	namespace-packages = true
+//	exclude = ["migrations/", "src/legacy", "**/generated_*.py"]
+//	test-patterns = ["test_*.py"]
+//	follow = ["runtime", "dynamic"]
+//	python-version = "3.11"
+//
+//	[tool.pyast.implicit]
+//	"src/app/urls.py" = ["src/app/views.py"]
+type Config struct {
+	Roots             []string `toml:"roots"`
+	NamespacePackages bool     `toml:"namespace-packages"`
+	// Exclude are patterns, in .gitignore syntax, of paths which are not
+	// scanned. See BuildTreesOptions.Exclude.
+	Exclude []string `toml:"exclude"`
+	// Gitignore can be set to false to scan files ignored by git.
+	Gitignore    *bool    `toml:"gitignore"`
+	TestPatterns []string `toml:"test-patterns"`
+	// Implicit maps globs of importing files to files they depend on,
+	// without importing them.
+	Implicit map[string][]string `toml:"implicit"`
+	// Follow lists the import kinds which are followed, eg "runtime" and
+	// "dynamic". Runtime imports are always followed. Defaults to every kind.
+	Follow           []string `toml:"follow"`
+	Pytest           bool     `toml:"pytest"`
+	StringReferences bool     `toml:"string-references"`
+	// Cache is where the imports of each file are cached: "none", "memory",
+	// "default" or a directory. See ParseImportCache.
+	Cache string `toml:"cache"`
+	// PythonVersion, such as "3.11", decides which imports are of the
+	// standard library.
+	PythonVersion string `toml:"python-version"`
+
+	// Path is the file the config was read from.
+	Path string `toml:"-"`
+}
+
+// Dir is the directory which paths in the config are relative to.
+func (c *Config) Dir() string {
+	return filepath.Dir(c.Path)
+}
+
+func (c *Config) resolve(path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(c.Dir(), path)
+}
+
+// resolvePattern makes an exclude pattern which is relative to the config
+// file absolute, keeping any leading ! and trailing /.
+func (c *Config) resolvePattern(pattern string) string {
+	negated := strings.HasPrefix(pattern, "!")
+	trimmed := strings.TrimRight(strings.TrimPrefix(pattern, "!"), "/")
+	if !strings.Contains(trimmed, "/") {
+		return pattern
+	}
+	result := filepath.Join(c.Dir(), trimmed)
+	if strings.HasSuffix(pattern, "/") {
+		result += "/"
+	}
+	if negated {
+		result = "!" + result
+	}
+	return result
+}
+
+// LoadConfig reads a config file. If it is named pyproject.toml, the
+// [tool.pyast] table is used, and (nil, nil) is returned if it has none.
+func LoadConfig(path string) (*Config, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{Path: path}
+	if filepath.Base(path) == "pyproject.toml" {
+		var pyproject struct {
+			Tool struct {
+				Pyast *Config `toml:"pyast"`
+			} `toml:"tool"`
+		}
+		pyproject.Tool.Pyast = config
+		metadata, err := toml.Decode(string(content), &pyproject)
+		if err != nil {
+			return nil, fmt.Errorf("while reading %v: %w", path, err)
+		}
+		if !metadata.IsDefined("tool", "pyast") {
+			return nil, nil
+		}
+		for _, key := range metadata.Undecoded() {
+			if len(key) > 1 && key[0] == "tool" && key[1] == "pyast" {
+				return nil, fmt.Errorf("unknown setting %v in %v", key, path)
+			}
+		}
+	} else {
+		metadata, err := toml.Decode(string(content), config)
+		if err != nil {
+			return nil, fmt.Errorf("while reading %v: %w", path, err)
+		}
+		if undecoded := metadata.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("unknown setting %v in %v", undecoded[0], path)
+		}
+	}
+	if _, err := config.ignore(); err != nil {
+		return nil, fmt.Errorf("while reading %v: %w", path, err)
+	}
+	if _, err := ParsePythonVersion(config.PythonVersion); err != nil {
+		return nil, fmt.Errorf("while reading %v: %w", path, err)
+	}
+	return config, nil
+}
+
+// FindConfig looks for ConfigFiles in dir and each directory above it,
+// returning the first config found, or nil if there is none.
+func FindConfig(dir string) (*Config, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		for _, name := range ConfigFiles {
+			path := filepath.Join(dir, name)
+			if !file.FileExists(path) {
+				continue
+			}
+			config, err := LoadConfig(path)
+			if err != nil {
+				return nil, err
+			}
+			if config != nil {
+				return config, nil
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+// RootPaths are the absolute paths of the configured roots.
+func (c *Config) RootPaths() file.Paths {
+	result := file.CreatePaths()
+	for _, root := range c.Roots {
+		result.Add(c.resolve(root))
+	}
+	return result
+}
+
+func (c *Config) ignore() (ImportKind, error) {
+	if len(c.Follow) == 0 {
+		return ImportRuntime, nil
+	}
+	var follow ImportKind
+	for _, name := range c.Follow {
+		kind, err := ParseImportKind(name)
+		if err != nil {
+			return 0, err
+		}
+		follow |= kind
+	}
+	return AllImportKinds &^ follow, nil
+}
+
+// QueryOptions are the configured QueryOptions.
+func (c *Config) QueryOptions() QueryOptions {
+	// the kinds were checked when the config was loaded
+	ignore, _ := c.ignore()
+	return QueryOptions{Ignore: ignore}
+}
+
+// BuildTreesOptions are the configured BuildTreesOptions.
+func (c *Config) BuildTreesOptions() BuildTreesOptions {
+	opts := BuildTreesOptions{NamespacePackages: c.NamespacePackages}
+	for _, pattern := range c.Exclude {
+		opts.Exclude = append(opts.Exclude, c.resolvePattern(pattern))
+	}
+	opts.NoGitignore = c.Gitignore != nil && !*c.Gitignore
+	opts.PythonVersion = c.PythonVersion
+	switch c.Cache {
+	case "", "default", "none", "memory":
+		opts.Cache = ParseImportCache(c.Cache)
+	default:
+		opts.Cache = NewDirectoryCache(c.resolve(c.Cache))
+	}
+	if len(c.Implicit) > 0 {
+		opts.ImplicitImports = make(map[string][]string, len(c.Implicit))
+		for pattern, imported := range c.Implicit {
+			paths := make([]string, len(imported))
+			for i, path := range imported {
+				paths[i] = c.resolve(path)
+			}
+			opts.ImplicitImports[c.resolve(pattern)] = paths
+		}
+	}
+	if c.Pytest {
+		opts.Pytest = &PytestOptions{TestPatterns: c.TestPatterns}
+	}
+	if c.StringReferences {
+		refs := DefaultStringReferences
+		opts.StringReferences = &refs
+	}
+	return opts
+}
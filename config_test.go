@@ -0,0 +1,82 @@
+package pyast
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	file "github.com/nicois/file"
+)
+
+func TestFindConfig(t *testing.T) {
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+	writeFile(t, filepath.Join(dir, "pyproject.toml"), `[project]
+name = "acme"
+
+[tool.black]
+line-length = 100
+
+[tool.pyast]
+roots = ["src"]
+exclude = ["migrations", "src/acme/legacy"]
+test-patterns = ["check_*.py"]
+follow = ["runtime", "dynamic"]
+
+[tool.pyast.implicit]
+"src/acme/urls.py" = ["src/acme/views.py"]
+`)
+	writeFile(t, filepath.Join(dir, "src/acme/__init__.py"), "")
+	writeFile(t, filepath.Join(dir, "src/acme/urls.py"), "")
+	writeFile(t, filepath.Join(dir, "src/acme/views.py"), "")
+	writeFile(t, filepath.Join(dir, "src/acme/migrations/__init__.py"), "")
+	writeFile(t, filepath.Join(dir, "src/acme/migrations/m0001.py"), "import acme.views\n")
+	writeFile(t, filepath.Join(dir, "src/acme/legacy/__init__.py"), "import acme.views\n")
+
+	config, err := FindConfig(filepath.Join(dir, "src/acme"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config == nil || config.Path != filepath.Join(dir, "pyproject.toml") {
+		t.Fatalf("Found unexpected config: %+v", config)
+	}
+	src := filepath.Join(dir, "src")
+	if roots := config.RootPaths(); !reflect.DeepEqual(roots, file.CreatePaths(src)) {
+		t.Fatalf("Found unexpected roots: %v", roots)
+	}
+	if ignore := config.QueryOptions().Ignore; ignore != AllImportKinds&^ImportDynamic {
+		t.Fatalf("Found unexpected ignored kinds: %v", ignore)
+	}
+	opts := config.BuildTreesOptions()
+	if expected := []string{"migrations", filepath.Join(src, "acme/legacy")}; !reflect.DeepEqual(opts.Exclude, expected) {
+		t.Fatalf("Found unexpected exclusions: %v", opts.Exclude)
+	}
+
+	trees, err := BuildTreesWithOptions(context.Background(), config.RootPaths(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	views := filepath.Join(src, "acme/views.py")
+	dependees, err := trees.GetDependees(file.CreatePaths(views))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := file.CreatePaths(views, filepath.Join(src, "acme/urls.py")); !reflect.DeepEqual(dependees, expected) {
+		t.Fatalf("Found unexpected dependees: %v", dependees)
+	}
+
+	// .pyast.toml takes precedence
+	writeFile(t, filepath.Join(dir, ".pyast.toml"), "roots = [\"lib\"]\n")
+	if config, err = FindConfig(src); err != nil || config.Path != filepath.Join(dir, ".pyast.toml") {
+		t.Fatalf("Found unexpected config: %+v %v", config, err)
+	}
+
+	writeFile(t, filepath.Join(dir, ".pyast.toml"), "rots = [\"lib\"]\n")
+	if _, err = FindConfig(src); err == nil {
+		t.Fatal("expected an error for an unknown setting")
+	}
+	writeFile(t, filepath.Join(dir, ".pyast.toml"), "follow = [\"sometimes\"]\n")
+	if _, err = FindConfig(src); err == nil {
+		t.Fatal("expected an error for an unknown import kind")
+	}
+}
@@ -0,0 +1,153 @@
+package pyast
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	file "github.com/nicois/file"
+)
+
+// ImportCycle is a strongly connected component of the module graph: a set
+// of modules which all import each other, directly or indirectly.
+type ImportCycle struct {
+	Modules []string     // paths of the participating modules, sorted
+	Imports []ImportStep // the imports between participating modules
+}
+
+func (c ImportCycle) String() string {
+	lines := []string{fmt.Sprintf("Cycle between %v modules:", len(c.Modules))}
+	for _, step := range c.Imports {
+		lines = append(lines, "\t"+step.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// importerPaths returns the paths of all modules which import something.
+func (t *trees) importerPaths() []string {
+	result := file.CreatePaths()
+	for _, tree := range *t {
+		for class := range tree.resolved {
+			result.Add(classFile(tree.root, class))
+		}
+	}
+	paths := make([]string, 0, len(result))
+	for path := range result {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// cycleSteps is importSteps without the packages which path only imports
+// because they contain a module it imports, as when pkg/models.py imports
+// pkg.helpers. Python initialises packages before their modules, so these
+// are not cycles even if the package's __init__.py imports path.
+func (t *trees) cycleSteps(path string, ignore ImportKind) []ImportStep {
+	class, ok := t.pathToClassAcrossTrees(path)
+	if !ok {
+		return nil
+	}
+	named := CreateClasses() // the packages it imports by their own name
+	if tree, ok := t.moduleTree(class); ok {
+		for _, name := range tree.names[class] {
+			if !ignore.ignores(name.Kind) {
+				named.Add(name.dotted() + ".__init__")
+			}
+		}
+	}
+	imports := t.getResolvedImportsAcrossTrees(class, ignore)
+	enclosing := CreateClasses()
+	for _, imported := range imports {
+		if _, ok := named[imported.Imported]; imported.Resolution == ResolvedPackage && !ok {
+			enclosing.Add(imported.Imported)
+		}
+	}
+	for _, imported := range imports {
+		if imported.Resolution != ResolvedPackage {
+			delete(enclosing, imported.Imported)
+		}
+	}
+	steps := t.importSteps(path, ignore)
+	result := steps[:0]
+	for _, step := range steps {
+		if _, ok := enclosing[step.Class]; !ok {
+			result = append(result, step)
+		}
+	}
+	return result
+}
+
+// ImportCycles finds every group of modules, across all trees, which
+// import each other in a cycle. The result is sorted, so it is stable
+// between runs over the same code.
+func (t *trees) ImportCycles() []ImportCycle {
+	return t.ImportCyclesWithOptions(QueryOptions{})
+}
+
+// ImportCyclesWithOptions is like ImportCycles, but does not follow imports
+// of the kinds in opts.Ignore. Packages which a module is only imported
+// through, rather than by name, are not part of its cycles. For example, ignoring ImportTypeChecking
+// finds only the cycles which matter at runtime.
+func (t *trees) ImportCyclesWithOptions(opts QueryOptions) []ImportCycle {
+	// Tarjan's strongly connected components algorithm
+	index := make(map[string]int)
+	lowlink := make(map[string]int)
+	onStack := make(map[string]bool)
+	steps := make(map[string][]ImportStep)
+	var stack []string
+	var result []ImportCycle
+
+	var connect func(path string)
+	connect = func(path string) {
+		index[path] = len(index)
+		lowlink[path] = index[path]
+		stack = append(stack, path)
+		onStack[path] = true
+		steps[path] = t.cycleSteps(path, opts.Ignore)
+		for _, step := range steps[path] {
+			if _, visited := index[step.Imported]; !visited {
+				connect(step.Imported)
+				lowlink[path] = min(lowlink[path], lowlink[step.Imported])
+			} else if onStack[step.Imported] {
+				lowlink[path] = min(lowlink[path], index[step.Imported])
+			}
+		}
+		if lowlink[path] != index[path] {
+			return
+		}
+		members := CreateClasses()
+		for {
+			member := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[member] = false
+			members.Add(member)
+			if member == path {
+				break
+			}
+		}
+		if len(members) < 2 {
+			return
+		}
+		cycle := ImportCycle{Modules: members.Lister()}
+		sort.Strings(cycle.Modules)
+		for _, member := range cycle.Modules {
+			for _, step := range steps[member] {
+				if _, ok := members[step.Imported]; ok {
+					cycle.Imports = append(cycle.Imports, step)
+				}
+			}
+		}
+		result = append(result, cycle)
+	}
+
+	for _, path := range t.importerPaths() {
+		if _, visited := index[path]; !visited {
+			connect(path)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Modules[0] < result[j].Modules[0]
+	})
+	return result
+}
@@ -0,0 +1,56 @@
+package pyast
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	file "github.com/nicois/file"
+)
+
+func TestImportCycles(t *testing.T) {
+	root, _ := filepath.Abs("testdata/cycles/src")
+	trees := BuildTrees(context.Background(), file.CreatePaths(root))
+
+	x := filepath.Join(root, "loop/x.py")
+	y := filepath.Join(root, "loop/y.py")
+	z := filepath.Join(root, "loop/z.py")
+
+	cycles := trees.ImportCycles()
+	if len(cycles) != 1 {
+		t.Fatalf("expected exactly one cycle, got %v", cycles)
+	}
+	if !reflect.DeepEqual(cycles[0].Modules, []string{x, y, z}) {
+		t.Errorf("Found unexpected modules in cycle:\n%v", cycles[0].Modules)
+	}
+	expected := []ImportStep{
+		{Importer: x, Imported: y, Class: "loop.y", Line: 2},
+		{Importer: y, Imported: z, Class: "loop.z", Line: 1},
+		{Importer: z, Imported: x, Class: "loop.x", Line: 1},
+	}
+	if !reflect.DeepEqual(cycles[0].Imports, expected) {
+		t.Errorf("Found unexpected imports in cycle:\n%v", cycles[0])
+	}
+}
+
+func TestImportCyclesThroughPackages(t *testing.T) {
+	root, _ := filepath.EvalSymlinks(t.TempDir())
+	// the package re-exports from a module, which imports a sibling
+	writeFile(t, filepath.Join(root, "pkg/__init__.py"), "from pkg.models import Model\n")
+	writeFile(t, filepath.Join(root, "pkg/models.py"), "import pkg.helpers\n")
+	writeFile(t, filepath.Join(root, "pkg/helpers.py"), "")
+	trees := BuildTrees(context.Background(), file.CreatePaths(root))
+	if cycles := trees.ImportCycles(); len(cycles) != 0 {
+		t.Fatalf("Found unexpected cycles: %v", cycles)
+	}
+
+	// but using the package by name is a cycle
+	writeFile(t, filepath.Join(root, "pkg/helpers.py"), "import pkg\n")
+	writeFile(t, filepath.Join(root, "pkg/models.py"), "from pkg import helpers\n")
+	trees = BuildTrees(context.Background(), file.CreatePaths(root))
+	cycles := trees.ImportCycles()
+	if expected := []string{filepath.Join(root, "pkg/__init__.py"), filepath.Join(root, "pkg/helpers.py"), filepath.Join(root, "pkg/models.py")}; len(cycles) != 1 || !reflect.DeepEqual(cycles[0].Modules, expected) {
+		t.Fatalf("Found unexpected cycles: %v", cycles)
+	}
+}
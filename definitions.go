@@ -0,0 +1,244 @@
+package pyast
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefinitionChangeKind is how a definition differs between two versions of a module.
+type DefinitionChangeKind int
+
+const (
+	DefinitionModified DefinitionChangeKind = iota
+	DefinitionAdded
+	DefinitionRemoved
+)
+
+func (k DefinitionChangeKind) String() string {
+	switch k {
+	case DefinitionAdded:
+		return "added"
+	case DefinitionRemoved:
+		return "removed"
+	}
+	return "modified"
+}
+
+// DefinitionChange is a top-level or class-level definition which differs
+// between two versions of a module. Name is dotted for a class-level
+// definition, as in "Page.title", and empty for top-level code which is not
+// part of a definition, such as a call, which runs when the module is imported.
+type DefinitionChange struct {
+	Name   string               `json:"name"`
+	Change DefinitionChangeKind `json:"change"`
+}
+
+// Symbol is the top-level symbol of the module at path which the change is within.
+func (c DefinitionChange) Symbol(path string) Symbol {
+	name, _, _ := strings.Cut(c.Name, ".")
+	return Symbol{Path: path, Name: name}
+}
+
+// ChangedDefinitions compares two versions of a module's source, finding
+// the definitions which were added, removed or modified, sorted by name.
+// Only tokens are compared, so changes to comments and layout are ignored,
+// as are docstrings outside of definitions.
+func ChangedDefinitions(old string, new string) []DefinitionChange {
+	before := definitions(old)
+	after := definitions(new)
+	var result []DefinitionChange
+	for name, tokens := range after {
+		if previous, ok := before[name]; !ok {
+			result = append(result, DefinitionChange{Name: name, Change: DefinitionAdded})
+		} else if previous != tokens {
+			result = append(result, DefinitionChange{Name: name, Change: DefinitionModified})
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			result = append(result, DefinitionChange{Name: name, Change: DefinitionRemoved})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// ChangedDefinitionsInDiff is ChangedDefinitions for a unified diff, such as
+// from `git diff`, of a single file, given its new content. It returns an
+// error if the diff does not apply to the content.
+func ChangedDefinitionsInDiff(content string, diff string) ([]DefinitionChange, error) {
+	old, err := unapplyDiff(content, diff)
+	if err != nil {
+		return nil, err
+	}
+	return ChangedDefinitions(old, content), nil
+}
+
+// definitions maps the name of each top-level and class-level definition to
+// the tokens of the statements which make it up, ignoring layout. The
+// statements of a function or class body belong to it, and those of a
+// method body to the method.
+func definitions(content string) map[string]string {
+	result := make(map[string]string)
+	add := func(owners []string, depth int, statement []token) {
+		var text strings.Builder
+		text.WriteString(strconv.Itoa(depth))
+		for _, tok := range statement {
+			text.WriteByte(' ')
+			text.WriteString(tok.value)
+		}
+		text.WriteByte('\n')
+		for _, owner := range owners {
+			result[owner] += text.String()
+		}
+	}
+	statements := logicalStatements(tokenize(content))
+	var top, class, member []string // the definitions which bodies belong to
+	var decorators [][]token
+	for i, blocks := range enclosingBlocks(statements) {
+		statement := statements[i]
+		depth := 0
+		for _, b := range blocks {
+			if b.keyword == "def" || b.keyword == "class" {
+				depth++
+			}
+		}
+		switch {
+		case depth > 1 || depth == 1 && class == nil:
+			// within a function, or a method
+			owners := top
+			if member != nil {
+				owners = member
+			}
+			add(owners, len(blocks), statement)
+			continue
+		case depth == 1 && statement[0].is(tokenOp, "@"):
+			decorators = append(decorators, statement)
+			continue
+		case depth == 1:
+			// directly within a class body
+			member = nil
+			owners := class
+			if names := boundNames(statement); len(names) > 0 && !isImport(statement) {
+				owners = nil
+				for _, name := range names {
+					owners = append(owners, class[0]+"."+name)
+				}
+				if defines(statement) {
+					member = owners
+				}
+			}
+			for _, decorator := range decorators {
+				add(owners, len(blocks), decorator)
+			}
+			decorators = nil
+			add(owners, len(blocks), statement)
+			continue
+		case statement[0].is(tokenOp, "@"):
+			decorators = append(decorators, statement)
+			continue
+		}
+		top, class, member = nil, nil, nil
+		names := boundNames(statement)
+		switch {
+		case len(names) > 0:
+			top = names
+			if statement[0].is(tokenName, "class") {
+				class = names
+			}
+		case len(statement) == 1 && statement[0].kind == tokenString:
+			// a docstring
+			continue
+		default:
+			top = []string{""}
+		}
+		for _, decorator := range decorators {
+			add(top, len(blocks), decorator)
+		}
+		decorators = nil
+		add(top, len(blocks), statement)
+		if !defines(statement) {
+			top = nil
+		}
+	}
+	return result
+}
+
+// isImport reports if a statement is an import statement.
+func isImport(statement []token) bool {
+	return statement[0].is(tokenName, "import") || statement[0].is(tokenName, "from")
+}
+
+var reHunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// unapplyDiff reverses a unified diff of a single file, given the new
+// content, returning the old content.
+func unapplyDiff(content string, diff string) (string, error) {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	diffLines := strings.Split(diff, "\n")
+	var old strings.Builder
+	next := 0 // the index of the first line of content not yet used
+	for i := 0; i < len(diffLines); i++ {
+		match := reHunkHeader.FindStringSubmatch(diffLines[i])
+		if match == nil {
+			continue
+		}
+		oldCount, newCount := 1, 1
+		if match[2] != "" {
+			oldCount, _ = strconv.Atoi(match[2])
+		}
+		newStart, _ := strconv.Atoi(match[3])
+		if match[4] != "" {
+			newCount, _ = strconv.Atoi(match[4])
+		}
+		if newCount > 0 {
+			// otherwise the hunk is after line newStart, rather than at it
+			newStart--
+		}
+		if newStart < next || newStart > len(lines) {
+			return "", fmt.Errorf("hunk %q does not apply", diffLines[i])
+		}
+		for ; next < newStart; next++ {
+			old.WriteString(lines[next])
+		}
+		for i+1 < len(diffLines) && (oldCount > 0 || newCount > 0) {
+			i++
+			line := diffLines[i]
+			if line == "" {
+				// some tools strip the space from empty context lines
+				line = " "
+			}
+			switch line[0] {
+			case ' ', '+':
+				if next >= len(lines) || strings.TrimRight(lines[next], "\r\n") != strings.TrimRight(line[1:], "\r") {
+					return "", fmt.Errorf("line %v of the diff does not match the content", i+1)
+				}
+				if line[0] == ' ' {
+					old.WriteString(lines[next])
+					oldCount--
+				}
+				next++
+				newCount--
+			case '-':
+				old.WriteString(line[1:] + "\n")
+				oldCount--
+			case '\\':
+				// no newline at end of file
+			default:
+				return "", fmt.Errorf("line %v of the diff is not part of a hunk: %q", i+1, line)
+			}
+		}
+	}
+	for ; next < len(lines); next++ {
+		old.WriteString(lines[next])
+	}
+	return old.String(), nil
+}
@@ -0,0 +1,126 @@
+package pyast
+
+import (
+	"reflect"
+	"testing"
+)
+
+const oldDefinitions = `"""Utilities."""
+import re
+from .models import User
+
+PATTERN = re.compile("-")
+
+def slugify(value):
+    return PATTERN.sub("", value)
+
+def unchanged(value):
+    # a comment
+    return value
+
+@cached
+def removed():
+    pass
+
+class Page(Base):
+    title = "page"
+
+    def render(self):
+        return self.title
+
+    def save(self):
+        pass
+
+print("loaded")
+`
+
+const newDefinitions = `"""Utilities, documented differently."""
+import re
+from .models import User, Group
+
+PATTERN = re.compile("-")
+
+def slugify(value):
+    return PATTERN.sub("_", value)
+
+def unchanged(value):
+    # a different comment
+
+    return value  # with a trailing comment
+
+class Page(Base):
+    title = "page"
+
+    def render(self):
+        return self.title.upper()
+
+    @property
+    def save(self):
+        pass
+
+    def publish(self):
+        pass
+
+def added():
+    pass
+
+print("loaded")
+`
+
+func TestChangedDefinitions(t *testing.T) {
+	expected := []DefinitionChange{
+		{Name: "Group", Change: DefinitionAdded},
+		{Name: "Page.publish", Change: DefinitionAdded},
+		{Name: "Page.render", Change: DefinitionModified},
+		{Name: "Page.save", Change: DefinitionModified},
+		{Name: "User", Change: DefinitionModified},
+		{Name: "added", Change: DefinitionAdded},
+		{Name: "removed", Change: DefinitionRemoved},
+		{Name: "slugify", Change: DefinitionModified},
+	}
+	if changes := ChangedDefinitions(oldDefinitions, newDefinitions); !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("Found unexpected changes: %+v", changes)
+	}
+
+	// top-level code which is not a definition changes the whole module
+	expected = []DefinitionChange{{Name: "", Change: DefinitionModified}}
+	if changes := ChangedDefinitions("print(1)\n", "print(2)\n"); !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("Found unexpected changes: %+v", changes)
+	}
+	if symbol := (DefinitionChange{Name: "Page.render"}).Symbol("/src/page.py"); symbol != (Symbol{Path: "/src/page.py", Name: "Page"}) {
+		t.Fatalf("Found unexpected symbol: %+v", symbol)
+	}
+}
+
+func TestChangedDefinitionsInDiff(t *testing.T) {
+	content := "import os\n\ndef a():\n    return 2\n\ndef c():\n    pass\n"
+	diff := `diff --git a/m.py b/m.py
+--- a/m.py
++++ b/m.py
+@@ -3,2 +3,2 @@
+ def a():
+-    return 1
++    return 2
+@@ -6,5 +6,2 @@
+-def b():
+-    pass
+-
+ def c():
+     pass
+`
+	expected := []DefinitionChange{
+		{Name: "a", Change: DefinitionModified},
+		{Name: "b", Change: DefinitionRemoved},
+	}
+	changes, err := ChangedDefinitionsInDiff(content, diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("Found unexpected changes: %+v", changes)
+	}
+
+	if _, err := ChangedDefinitionsInDiff("import sys\n", "@@ -1 +1 @@\n-import os\n+import json\n"); err == nil {
+		t.Fatal("expected an error for a diff which does not apply")
+	}
+}
@@ -0,0 +1,61 @@
+package pyast
+
+import (
+	file "github.com/nicois/file"
+)
+
+// QueryOptions controls how far graph queries travel.
+type QueryOptions struct {
+	// MaxDepth limits how many import hops are followed. Direct imports
+	// are at depth 1. Zero means there is no limit.
+	MaxDepth int
+
+	// Ignore is the set of import kinds which are not followed, such
+	// as ImportTypeChecking. Runtime imports are always followed.
+	Ignore ImportKind
+
+	// Canonical returns paths with symlinks resolved, rather than as they
+	// were reached from the roots.
+	Canonical bool
+}
+
+// GetDependencies returns the project files imported by the given paths,
+// directly or (subject to opts.MaxDepth) transitively. Imported names which
+// do not correspond to a file in any of the trees, such as third-party
+// packages or names imported from within a module, are ignored.
+// The input paths are only included if they are imported by one of the others.
+func (t *trees) GetDependencies(paths file.Paths, opts QueryOptions) (file.Paths, error) {
+	result := file.CreatePaths()
+	seen := CreateClasses()
+
+	pending := CreateClasses()
+	for path := range paths {
+		pending.Union(t.classesOf(path))
+	}
+
+	for depth := 1; len(pending) > 0 && (opts.MaxDepth == 0 || depth <= opts.MaxDepth); depth++ {
+		nextPending := CreateClasses()
+		for class := range pending {
+			if _, already := seen[class]; already {
+				continue
+			}
+			seen.Add(class)
+			for _, imported := range t.getResolvedImportsAcrossTrees(class, opts.Ignore) {
+				result.Add(imported.Path)
+				if _, already := seen[imported.Imported]; !already {
+					nextPending.Add(imported.Imported)
+				}
+			}
+		}
+		pending = nextPending
+	}
+	if opts.Canonical {
+		return t.canonicalPaths(result), nil
+	}
+	return result, nil
+}
+
+// GetDependencies returns the files within this tree imported by the given paths.
+func (t *tree) GetDependencies(paths file.Paths, opts QueryOptions) (file.Paths, error) {
+	return (&trees{*t}).GetDependencies(paths, opts)
+}
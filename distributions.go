@@ -0,0 +1,319 @@
+package pyast
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+var reDistributionSeparators = regexp.MustCompile(`[-_.]+`)
+
+// NormalizeDistributionName normalises the name of a distribution as pip
+// does, so that "Django", "django" and "zope_interface" match "zope.interface".
+func NormalizeDistributionName(name string) string {
+	return strings.ToLower(reDistributionSeparators.ReplaceAllString(strings.TrimSpace(name), "-"))
+}
+
+// ScanSitePackages maps the top-level modules installed in site-packages
+// directories to the normalised names of the distributions providing them,
+// using the top_level.txt and RECORD files of each .dist-info or .egg-info
+// directory. A module may be provided by several distributions, such as the
+// namespace package google.
+func ScanSitePackages(dirs ...string) (map[string][]string, error) {
+	result := make(map[string][]string)
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if !entry.IsDir() || !strings.HasSuffix(name, ".dist-info") && !strings.HasSuffix(name, ".egg-info") {
+				continue
+			}
+			metadata := filepath.Join(dir, name)
+			distribution := distributionName(metadata)
+			modules, err := distributionModules(metadata)
+			if err != nil {
+				return nil, fmt.Errorf("while reading %v: %w", metadata, err)
+			}
+			for _, module := range modules {
+				result[module] = appendNew(result[module], distribution)
+			}
+		}
+	}
+	for _, distributions := range result {
+		sort.Strings(distributions)
+	}
+	return result, nil
+}
+
+// distributionName reads the name of a distribution from its metadata,
+// falling back to the name of the metadata directory.
+func distributionName(metadata string) string {
+	for _, name := range []string{"METADATA", "PKG-INFO"} {
+		f, err := os.Open(filepath.Join(metadata, name))
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" {
+				// the end of the headers
+				break
+			}
+			if value, ok := strings.CutPrefix(line, "Name:"); ok {
+				return NormalizeDistributionName(value)
+			}
+		}
+	}
+	// name-version.dist-info
+	base := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(metadata), ".dist-info"), ".egg-info")
+	name, _, _ := strings.Cut(base, "-")
+	return NormalizeDistributionName(name)
+}
+
+// distributionModules finds the top-level modules which a distribution
+// installs, from top_level.txt if there is one, otherwise from RECORD.
+func distributionModules(metadata string) ([]string, error) {
+	content, err := os.ReadFile(filepath.Join(metadata, "top_level.txt"))
+	if err == nil {
+		var result []string
+		for _, line := range strings.Split(string(content), "\n") {
+			if module := strings.TrimSpace(line); module != "" {
+				result = appendNew(result, topLevel(strings.ReplaceAll(module, "/", ".")))
+			}
+		}
+		return result, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(metadata, "RECORD"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	var result []string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if module := recordModule(record[0]); module != "" {
+			result = appendNew(result, module)
+		}
+	}
+	return result, nil
+}
+
+// recordModule finds the top-level module which a path listed in RECORD
+// belongs to, or "" if it is not part of one, such as a script.
+func recordModule(path string) string {
+	first, rest, nested := strings.Cut(path, "/")
+	switch {
+	case strings.HasPrefix(path, "../") || strings.HasPrefix(path, "/"):
+		return ""
+	case strings.HasSuffix(first, ".dist-info") || strings.HasSuffix(first, ".egg-info") || strings.HasSuffix(first, ".data") || first == "__pycache__":
+		return ""
+	case nested && rest != "":
+		return first
+	case strings.HasSuffix(first, ".py"):
+		return strings.TrimSuffix(first, ".py")
+	case strings.HasSuffix(first, ".so") || strings.HasSuffix(first, ".pyd"):
+		// an extension module, such as _cffi_backend.cpython-311-x86_64-linux-gnu.so
+		module, _, _ := strings.Cut(first, ".")
+		return module
+	}
+	return ""
+}
+
+var reRequirementName = regexp.MustCompile(`^\s*([A-Za-z0-9][A-Za-z0-9._-]*)`)
+
+// DeclaredDependencies reads the normalised names of the distributions which
+// a pyproject.toml depends on: those in [project] dependencies and
+// optional-dependencies, [dependency-groups], and poetry's dependencies,
+// dev-dependencies and groups. The project itself, and python, are left out.
+func DeclaredDependencies(pyproject string) ([]string, error) {
+	var metadata struct {
+		Project struct {
+			Name                 string              `toml:"name"`
+			Dependencies         []string            `toml:"dependencies"`
+			OptionalDependencies map[string][]string `toml:"optional-dependencies"`
+		} `toml:"project"`
+		DependencyGroups map[string][]interface{} `toml:"dependency-groups"`
+		Tool             struct {
+			Poetry struct {
+				Name            string                 `toml:"name"`
+				Dependencies    map[string]interface{} `toml:"dependencies"`
+				DevDependencies map[string]interface{} `toml:"dev-dependencies"`
+				Group           map[string]struct {
+					Dependencies map[string]interface{} `toml:"dependencies"`
+				} `toml:"group"`
+			} `toml:"poetry"`
+		} `toml:"tool"`
+	}
+	if _, err := toml.DecodeFile(pyproject, &metadata); err != nil {
+		return nil, fmt.Errorf("while reading %v: %w", pyproject, err)
+	}
+	var requirements []string
+	requirements = append(requirements, metadata.Project.Dependencies...)
+	for _, group := range metadata.Project.OptionalDependencies {
+		requirements = append(requirements, group...)
+	}
+	for _, group := range metadata.DependencyGroups {
+		for _, requirement := range group {
+			// other entries include other groups
+			if requirement, ok := requirement.(string); ok {
+				requirements = append(requirements, requirement)
+			}
+		}
+	}
+	poetry := metadata.Tool.Poetry
+	for _, dependencies := range []map[string]interface{}{poetry.Dependencies, poetry.DevDependencies} {
+		for name := range dependencies {
+			requirements = append(requirements, name)
+		}
+	}
+	for _, group := range poetry.Group {
+		for name := range group.Dependencies {
+			requirements = append(requirements, name)
+		}
+	}
+
+	excluded := map[string]bool{"python": true}
+	for _, name := range []string{metadata.Project.Name, poetry.Name} {
+		if name != "" {
+			excluded[NormalizeDistributionName(name)] = true
+		}
+	}
+	var result []string
+	for _, requirement := range requirements {
+		match := reRequirementName.FindStringSubmatch(requirement)
+		if match == nil {
+			continue
+		}
+		if name := NormalizeDistributionName(match[1]); !excluded[name] {
+			result = appendNew(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// DependencyReport compares the distributions which a root imports with
+// those declared by the nearest pyproject.toml at or above it.
+type DependencyReport struct {
+	// Pyproject is the file the declared dependencies were read from, or
+	// "" if there is none.
+	Pyproject string `json:"pyproject,omitempty"`
+	// Undeclared are the distributions which provide third-party modules
+	// imported by the root, but are not declared. A module provided by
+	// several distributions is only undeclared if none of them are declared.
+	Undeclared []string `json:"undeclared,omitempty"`
+	// Unused are the declared distributions which no module of the root
+	// imports. A module which is not installed is assumed to be provided by
+	// the distribution named like it, as python_dateutil by "python-dateutil".
+	Unused []string `json:"unused,omitempty"`
+	// Unknown are the third-party modules which no installed distribution provides.
+	Unknown []string `json:"unknown,omitempty"`
+}
+
+// CheckDependencies reports, for each root, the imported distributions which
+// are not declared in its pyproject.toml and the declared ones which are not
+// imported. A declared distribution is only unused if none of the roots
+// sharing the pyproject.toml, such as src and tests, import it. installed
+// maps top-level modules to the distributions providing them, as returned by
+// ScanSitePackages. Imports of the ignored kinds are not counted.
+func (t *trees) CheckDependencies(installed map[string][]string, ignore ImportKind) (map[string]DependencyReport, error) {
+	result := make(map[string]DependencyReport)
+	declared := make(map[string][]string)        // by pyproject.toml
+	imported := make(map[string]map[string]bool) // by pyproject.toml
+	for root, dependencies := range t.ExternalDependenciesByRoot(ignore) {
+		var report DependencyReport
+		isDeclared := make(map[string]bool)
+		if pyproject, ok := findPyproject(root); ok {
+			if _, ok := declared[pyproject]; !ok {
+				names, err := DeclaredDependencies(pyproject)
+				if err != nil {
+					return nil, err
+				}
+				declared[pyproject] = names
+				imported[pyproject] = make(map[string]bool)
+			}
+			for _, name := range declared[pyproject] {
+				isDeclared[name] = true
+			}
+			report.Pyproject = pyproject
+		}
+		uses := imported[report.Pyproject]
+		if uses == nil {
+			uses = make(map[string]bool)
+		}
+		for _, module := range dependencies.ThirdParty {
+			distributions := installed[module]
+			if len(distributions) == 0 {
+				report.Unknown = append(report.Unknown, module)
+				uses[NormalizeDistributionName(module)] = true
+				continue
+			}
+			found := false
+			for _, distribution := range distributions {
+				uses[distribution] = true
+				found = found || isDeclared[distribution]
+			}
+			if !found {
+				report.Undeclared = appendNew(report.Undeclared, distributions...)
+			}
+		}
+		sort.Strings(report.Undeclared)
+		result[root] = report
+	}
+	for root, report := range result {
+		if report.Pyproject == "" {
+			continue
+		}
+		for _, name := range declared[report.Pyproject] {
+			if !imported[report.Pyproject][name] {
+				report.Unused = append(report.Unused, name)
+			}
+		}
+		result[root] = report
+	}
+	return result, nil
+}
+
+// findPyproject finds the nearest pyproject.toml in dir or above it.
+func findPyproject(dir string) (string, bool) {
+	for {
+		path := filepath.Join(dir, "pyproject.toml")
+		if _, err := os.Stat(path); err == nil {
+			return path, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,107 @@
+package pyast
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	file "github.com/nicois/file"
+)
+
+func TestScanSitePackages(t *testing.T) {
+	sitePackages := t.TempDir()
+	writeFile(t, filepath.Join(sitePackages, "requests-2.31.0.dist-info/METADATA"), "Metadata-Version: 2.1\nName: requests\nVersion: 2.31.0\n\nName: not a header\n")
+	writeFile(t, filepath.Join(sitePackages, "requests-2.31.0.dist-info/top_level.txt"), "requests\n")
+	writeFile(t, filepath.Join(sitePackages, "PyYAML-6.0.dist-info/METADATA"), "Metadata-Version: 2.1\nName: PyYAML\n")
+	writeFile(t, filepath.Join(sitePackages, "PyYAML-6.0.dist-info/RECORD"), "yaml/__init__.py,sha256=abc,10\nyaml/__pycache__/__init__.cpython-311.pyc,,\n_yaml.cpython-311-x86_64-linux-gnu.so,,\nPyYAML-6.0.dist-info/RECORD,,\n../../bin/yaml,,\n")
+	writeFile(t, filepath.Join(sitePackages, "python_dateutil-2.8.2.dist-info/RECORD"), "dateutil/__init__.py,,\n")
+	writeFile(t, filepath.Join(sitePackages, "six-1.16.0.dist-info/RECORD"), "six.py,,\n")
+	writeFile(t, filepath.Join(sitePackages, "protobuf-4.25.0.dist-info/top_level.txt"), "google\n")
+	writeFile(t, filepath.Join(sitePackages, "googleapis_common_protos-1.62.0.dist-info/top_level.txt"), "google\n")
+	writeFile(t, filepath.Join(sitePackages, "not-metadata/top_level.txt"), "ignored\n")
+	installed, err := ScanSitePackages(sitePackages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]string{
+		"requests": {"requests"},
+		"yaml":     {"pyyaml"},
+		"_yaml":    {"pyyaml"},
+		"dateutil": {"python-dateutil"},
+		"six":      {"six"},
+		"google":   {"googleapis-common-protos", "protobuf"},
+	}
+	if !reflect.DeepEqual(installed, expected) {
+		t.Fatalf("Found unexpected distributions: %v", installed)
+	}
+
+	project, _ := filepath.EvalSymlinks(t.TempDir())
+	writeFile(t, filepath.Join(project, "pyproject.toml"), `[project]
+name = "acme"
+dependencies = ["requests>=2", "PyYAML[libyaml] ; python_version > '3'", "Flask"]
+
+[project.optional-dependencies]
+dev = ["pytest", "acme[extra]"]
+
+[dependency-groups]
+lint = ["ruff", {include-group = "dev"}]
+`)
+	writeFile(t, filepath.Join(project, "src/acme/__init__.py"), "")
+	writeFile(t, filepath.Join(project, "src/acme/app.py"), "import requests\nimport yaml\nimport dateutil\nimport google.protobuf\nimport missing_package\nimport os\n")
+	writeFile(t, filepath.Join(project, "tests/test_app.py"), "import pytest\nimport acme.app\n")
+	declared, err := DeclaredDependencies(filepath.Join(project, "pyproject.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"flask", "pytest", "pyyaml", "requests", "ruff"}; !reflect.DeepEqual(declared, expected) {
+		t.Fatalf("Found unexpected declared dependencies: %v", declared)
+	}
+
+	src := filepath.Join(project, "src")
+	tests := filepath.Join(project, "tests")
+	trees, err := BuildTreesWithOptions(context.Background(), file.CreatePaths(src, tests), BuildTreesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reports, err := trees.CheckDependencies(installed, ImportRuntime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pyproject := filepath.Join(project, "pyproject.toml")
+	expectedReports := map[string]DependencyReport{
+		src: {
+			Pyproject:  pyproject,
+			Undeclared: []string{"googleapis-common-protos", "protobuf", "python-dateutil"},
+			Unused:     []string{"flask", "ruff"},
+			Unknown:    []string{"missing_package"},
+		},
+		// pytest is not unused, although src does not import it
+		tests: {Pyproject: pyproject, Unused: []string{"flask", "ruff"}, Unknown: []string{"pytest"}},
+	}
+	if !reflect.DeepEqual(reports, expectedReports) {
+		t.Fatalf("Found unexpected reports: %+v", reports)
+	}
+}
+
+func TestDeclaredPoetryDependencies(t *testing.T) {
+	pyproject := filepath.Join(t.TempDir(), "pyproject.toml")
+	writeFile(t, pyproject, `[tool.poetry]
+name = "acme"
+
+[tool.poetry.dependencies]
+python = "^3.11"
+Django = "^4.2"
+celery = {version = "^5", extras = ["redis"]}
+
+[tool.poetry.group.dev.dependencies]
+pytest = "*"
+`)
+	declared, err := DeclaredDependencies(pyproject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"celery", "django", "pytest"}; !reflect.DeepEqual(declared, expected) {
+		t.Fatalf("Found unexpected declared dependencies: %v", declared)
+	}
+}
@@ -0,0 +1,169 @@
+package pyast
+
+import (
+	"strings"
+)
+
+// dynamicImporters are the functions which import the module named by their
+// first argument.
+var dynamicImporters = map[string]bool{
+	"import_module": true,
+	"__import__":    true,
+}
+
+// specFinders finds the names which importlib.util.find_spec is called by in
+// a module, given its import statements, such as "find_spec" after `from
+// importlib.util import find_spec`. find_spec does not execute the module,
+// but code which looks for one usually goes on to import it. As other
+// objects have find_spec methods too, only these names are followed.
+func specFinders(imports []importStatement) map[string]bool {
+	result := map[string]bool{"importlib.util.find_spec": true}
+	for _, s := range imports {
+		for i, name := range s.names {
+			bound := s.alias[i]
+			if bound == "" {
+				bound = name
+			}
+			switch {
+			case s.from && s.level == 0 && s.module == "importlib.util" && name == "find_spec":
+				result[bound] = true
+			case s.from && s.level == 0 && s.module == "importlib" && name == "util":
+				result[bound+".find_spec"] = true
+			case !s.from && name == "importlib.util" && s.alias[i] != "":
+				result[bound+".find_spec"] = true
+			}
+		}
+	}
+	return result
+}
+
+// calleeName is the dotted name of the function called at statement[i],
+// such as "importlib.util.find_spec", or "" if it is an attribute of
+// something else, such as the result of another call.
+func calleeName(statement []token, i int) string {
+	name := statement[i].value
+	for ; i > 0 && statement[i-1].is(tokenOp, "."); i -= 2 {
+		if i < 2 || statement[i-2].kind != tokenName {
+			return ""
+		}
+		name = statement[i-2].value + "." + name
+	}
+	return name
+}
+
+// parseDynamicImports finds calls to dynamicImporters and specFinders with a
+// literal module name, such as importlib.import_module("acme.plugins.x"),
+// within a statement. Relative names are supported if the package argument
+// is __name__, __package__ or a literal.
+func parseDynamicImports(statement []token, finders map[string]bool) []importStatement {
+	var result []importStatement
+	for i := 0; i+3 < len(statement); i++ {
+		if statement[i].kind != tokenName || !statement[i+1].is(tokenOp, "(") {
+			continue
+		}
+		if !dynamicImporters[statement[i].value] && !finders[calleeName(statement, i)] {
+			continue
+		}
+		if i > 0 && (statement[i-1].is(tokenName, "def") || statement[i-1].is(tokenName, "class")) {
+			continue
+		}
+		if statement[i+2].kind != tokenString {
+			continue
+		}
+		name, ok := stringLiteral(statement[i+2].value)
+		if !ok || name == "" {
+			continue
+		}
+		// anything other than the end of the call or another argument means the
+		// name is an expression, such as "acme." + name
+		if next := statement[i+3]; !next.is(tokenOp, ")") && !next.is(tokenOp, ",") {
+			continue
+		}
+		s, ok := dynamicImportStatement(name, dynamicPackage(statement[i+3:], statement[i].value != "__import__"))
+		if !ok {
+			continue
+		}
+		s.line = statement[i].line
+		result = append(result, s)
+	}
+	return result
+}
+
+// dynamicPackage finds the package argument of a call, given the tokens
+// after its first argument. It returns "" if there is none, or it is
+// not understood. If positional, the package may be the second argument.
+func dynamicPackage(rest []token, positional bool) string {
+	if positional && len(rest) >= 3 && rest[0].is(tokenOp, ",") && (rest[2].is(tokenOp, ")") || rest[2].is(tokenOp, ",")) {
+		return packageArgument(rest[1])
+	}
+	depth := 0
+	for i, tok := range rest {
+		if tok.kind == tokenOp {
+			switch tok.value {
+			case "(", "[", "{":
+				depth++
+			case ")", "]", "}":
+				depth--
+				if depth < 0 {
+					return ""
+				}
+			}
+		}
+		if depth == 0 && tok.is(tokenName, "package") && i+2 < len(rest) && rest[i+1].is(tokenOp, "=") {
+			return packageArgument(rest[i+2])
+		}
+	}
+	return ""
+}
+
+// packageArgument interprets the package passed to import_module or find_spec.
+func packageArgument(tok token) string {
+	switch tok.kind {
+	case tokenName:
+		if tok.value == "__name__" || tok.value == "__package__" {
+			return tok.value
+		}
+	case tokenString:
+		if literal, ok := stringLiteral(tok.value); ok {
+			return literal
+		}
+	}
+	return ""
+}
+
+// dynamicImportStatement converts a module name, and the package it may
+// be relative to, into the equivalent import statement.
+func dynamicImportStatement(name string, pkg string) (importStatement, bool) {
+	level := len(name) - len(strings.TrimLeft(name, "."))
+	if level == 0 {
+		return importStatement{names: []string{name}, kind: ImportDynamic}, true
+	}
+	relative := name[level:]
+	if relative == "" {
+		// the package itself, which is imported anyway
+		return importStatement{}, false
+	}
+	var module, last string
+	if i := strings.LastIndex(relative, "."); i >= 0 {
+		module, last = relative[:i], relative[i+1:]
+	} else {
+		last = relative
+	}
+	switch pkg {
+	case "":
+		return importStatement{}, false
+	case "__package__":
+		return importStatement{from: true, level: level, module: module, names: []string{last}, kind: ImportDynamic}, true
+	case "__name__":
+		return importStatement{from: true, level: level, module: module, names: []string{last}, relativeToName: true, kind: ImportDynamic}, true
+	}
+	parts := strings.Split(pkg, ".")
+	if level-1 >= len(parts) {
+		return importStatement{}, false
+	}
+	parts = parts[:len(parts)-(level-1)]
+	if module != "" {
+		parts = append(parts, module)
+	}
+	return importStatement{from: true, module: strings.Join(parts, "."), names: []string{last}, kind: ImportDynamic}, true
+}
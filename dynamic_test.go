@@ -0,0 +1,78 @@
+package pyast
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDynamicImports(t *testing.T) {
+	source := `import importlib
+from importlib import import_module
+
+plugin = importlib.import_module("acme.plugins.x")
+y = __import__("acme.y")
+sibling = import_module(".sibling", package=__package__)
+child = import_module(".child", __name__)
+spec = importlib.util.find_spec("..other.thing", package="acme.plugins")
+computed = importlib.import_module("acme." + name)
+formatted = importlib.import_module(f"acme.{name}")
+relative_without_package = import_module(".lost")
+
+def load():
+    return import_module("acme.lazy")
+
+def import_module(name):
+    pass
+`
+	names, err := extractImportedNames("acme.loader", source, parseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []importedName
+	for _, name := range names {
+		if name.Kind&ImportDynamic != 0 {
+			found = append(found, name)
+		}
+	}
+	expected := []importedName{
+		{Name: "acme.plugins.x", Line: 4, Kind: ImportDynamic},
+		{Name: "acme.y", Line: 5, Kind: ImportDynamic},
+		{Package: "acme", Name: "sibling", Line: 6, Kind: ImportDynamic},
+		{Package: "acme.loader", Name: "child", Line: 7, Kind: ImportDynamic},
+		{Package: "acme.other", Name: "thing", Line: 8, Kind: ImportDynamic},
+		{Name: "acme.lazy", Line: 14, Kind: ImportDynamic | ImportFunctionLocal},
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Fatalf("Found unexpected dynamic imports: %+v", found)
+	}
+
+	// only importlib.util's find_spec, not the methods of other objects
+	source = `from importlib.util import find_spec as locate
+from importlib import util
+locate("acme.located")
+util.find_spec("acme.found")
+registry.find_spec("acme.registered")
+self.finder.find_spec("acme.method")
+find_spec("acme.unbound")
+`
+	names, _ = extractImportedNames("acme.loader", source, parseOptions{})
+	found = nil
+	for _, name := range names {
+		if name.Kind&ImportDynamic != 0 {
+			found = append(found, name)
+		}
+	}
+	expected = []importedName{
+		{Name: "acme.located", Line: 3, Kind: ImportDynamic},
+		{Name: "acme.found", Line: 4, Kind: ImportDynamic},
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Fatalf("Found unexpected dynamic imports: %+v", found)
+	}
+
+	// within a package's __init__, __name__ is the package
+	names, _ = extractImportedNames("acme.plugins.__init__", `import_module(".child", package=__name__)`, parseOptions{})
+	if expected := []importedName{{Package: "acme.plugins", Name: "child", Line: 1, Kind: ImportDynamic}}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("Found unexpected dynamic imports: %+v", names)
+	}
+}
@@ -0,0 +1,90 @@
+package pyast
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// FileError records why a single file (or python root) could not be processed.
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Path, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// BuildError aggregates the problems encountered while building trees.
+// Each of the files listed is missing from the resulting graph, or only
+// some of its imports are included.
+type BuildError struct {
+	Errors []*FileError
+}
+
+func (e *BuildError) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = "\t" + err.Error()
+	}
+	return fmt.Sprintf("%v files could not be processed:\n%v", len(e.Errors), strings.Join(messages, "\n"))
+}
+
+func (e *BuildError) Unwrap() []error {
+	result := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		result[i] = err
+	}
+	return result
+}
+
+// partialError means only some of a file's imports could be determined.
+type partialError struct {
+	problem string
+}
+
+func (e *partialError) Error() string {
+	return e.problem
+}
+
+// buildErrors collects FileErrors from the goroutines building a tree.
+type buildErrors struct {
+	mutex  *sync.Mutex
+	errors []*FileError
+	cancel context.CancelFunc // if set, called on the first error
+}
+
+func createBuildErrors(cancel context.CancelFunc) *buildErrors {
+	return &buildErrors{mutex: new(sync.Mutex), cancel: cancel}
+}
+
+func (b *buildErrors) add(path string, err error) {
+	log.Debugf("Skipping %v: %v", path, err)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.errors = append(b.errors, &FileError{Path: path, Err: err})
+	if b.cancel != nil {
+		b.cancel()
+	}
+}
+
+// err returns a *BuildError if anything was added, otherwise nil.
+func (b *buildErrors) err() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if len(b.errors) == 0 {
+		return nil
+	}
+	return &BuildError{Errors: append([]*FileError{}, b.errors...)}
+}
@@ -0,0 +1,43 @@
+package pyast
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	file "github.com/nicois/file"
+)
+
+func TestBuildTreesBestEffort(t *testing.T) {
+	root, _ := filepath.Abs("testdata/errors/src")
+	climb := filepath.Join(root, "broken/climb.py")
+	fine := filepath.Join(root, "broken/fine.py")
+
+	trees, err := BuildTreesWithOptions(context.Background(), file.CreatePaths(root), BuildTreesOptions{})
+	var buildError *BuildError
+	if !errors.As(err, &buildError) {
+		t.Fatalf("expected a BuildError, got: %v", err)
+	}
+	if len(buildError.Errors) != 1 || buildError.Errors[0].Path != climb {
+		t.Errorf("expected only %v to be reported, got: %v", climb, err)
+	}
+	if trees == nil {
+		t.Fatal("expected a partial graph")
+	}
+	deps, err := trees.GetDependees(file.CreatePaths(climb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := deps[fine]; !ok {
+		t.Errorf("expected fine.py to depend on climb.py, got: %v", deps)
+	}
+}
+
+func TestBuildTreesFailFast(t *testing.T) {
+	root, _ := filepath.Abs("testdata/errors/src")
+	trees, err := BuildTreesWithOptions(context.Background(), file.CreatePaths(root), BuildTreesOptions{FailFast: true})
+	if err == nil || trees != nil {
+		t.Fatalf("expected only an error, got %v and %v", trees, err)
+	}
+}
@@ -0,0 +1,145 @@
+package pyast
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ImportStep is a single edge in an import chain.
+type ImportStep struct {
+	Importer string // path of the importing file
+	Imported string // path of the imported file
+	Class    string // the class of the imported file
+	Line     int    // line of the import statement within Importer
+	Kind     ImportKind
+}
+
+func (s ImportStep) String() string {
+	if s.Kind != ImportRuntime {
+		return fmt.Sprintf("%v:%v imports %v (%v)", s.Importer, s.Line, s.Class, s.Kind)
+	}
+	return fmt.Sprintf("%v:%v imports %v", s.Importer, s.Line, s.Class)
+}
+
+// ImportChain is a sequence of imports leading from a dependee to a file it depends on.
+type ImportChain []ImportStep
+
+func (c ImportChain) String() string {
+	steps := make([]string, len(c))
+	for i, step := range c {
+		steps[i] = step.String()
+	}
+	return strings.Join(steps, "\n")
+}
+
+// importSteps returns an ImportStep for each file directly imported by path,
+// sorted by imported path. Where several import statements resolve to the
+// same file, the earliest one is used. Imports of the ignored kinds are skipped.
+func (t *trees) importSteps(path string, ignore ImportKind) []ImportStep {
+	class, ok := t.pathToClassAcrossTrees(path)
+	if !ok {
+		return nil
+	}
+	var steps []ImportStep
+	for _, imported := range t.getResolvedImportsAcrossTrees(class, ignore) {
+		if imported.Path == path {
+			continue
+		}
+		steps = append(steps, ImportStep{Importer: path, Imported: imported.Path, Class: imported.Imported, Line: imported.Line, Kind: imported.Kind})
+	}
+	sort.Slice(steps, func(i, j int) bool {
+		if steps[i].Imported != steps[j].Imported {
+			return steps[i].Imported < steps[j].Imported
+		}
+		if steps[i].Line != steps[j].Line {
+			return steps[i].Line < steps[j].Line
+		}
+		return steps[i].Class < steps[j].Class
+	})
+	result := steps[:0]
+	for _, step := range steps {
+		if len(result) == 0 || result[len(result)-1].Imported != step.Imported {
+			result = append(result, step)
+		}
+	}
+	return result
+}
+
+// Explain returns the shortest import chains by which dependee depends on
+// changed, up to limit chains (zero means no limit), following imports as
+// GetDependencies does with opts. If dependee does not depend on changed,
+// no chains are returned.
+func (t *trees) Explain(dependee string, changed string, limit int, opts QueryOptions) ([]ImportChain, error) {
+	for _, path := range []string{dependee, changed} {
+		if !filepath.IsAbs(path) {
+			return nil, fmt.Errorf("%v should be absolute already", path)
+		}
+		if _, ok := t.pathToClassAcrossTrees(path); !ok {
+			return nil, fmt.Errorf("%v is not a python file within any of the trees", path)
+		}
+	}
+	if dependee == changed {
+		return []ImportChain{{}}, nil
+	}
+
+	// breadth-first search, remembering every way each file
+	// was reached at its shortest distance
+	distance := map[string]int{dependee: 0}
+	parents := make(map[string][]ImportStep)
+	frontier := []string{dependee}
+	for depth := 1; len(frontier) > 0 && (opts.MaxDepth == 0 || depth <= opts.MaxDepth); depth++ {
+		if _, found := distance[changed]; found {
+			break
+		}
+		var next []string
+		for _, path := range frontier {
+			for _, step := range t.importSteps(path, opts.Ignore) {
+				d, visited := distance[step.Imported]
+				if !visited {
+					distance[step.Imported] = depth
+					next = append(next, step.Imported)
+				} else if d != depth {
+					continue
+				}
+				parents[step.Imported] = append(parents[step.Imported], step)
+			}
+		}
+		frontier = next
+	}
+	if _, found := distance[changed]; !found {
+		return nil, nil
+	}
+
+	var result []ImportChain
+	var walk func(path string, suffix ImportChain) bool
+	walk = func(path string, suffix ImportChain) bool {
+		if path == dependee {
+			chain := make(ImportChain, len(suffix))
+			for i, step := range suffix {
+				chain[len(suffix)-1-i] = step
+			}
+			result = append(result, chain)
+			return limit == 0 || len(result) < limit
+		}
+		for _, step := range parents[path] {
+			if !walk(step.Importer, append(suffix, step)) {
+				return false
+			}
+		}
+		return true
+	}
+	walk(changed, nil)
+	return result, nil
+}
+
+// ShortestPath returns one of the shortest import chains by which dependee
+// depends on changed, or nil if it does not.
+func (t *trees) ShortestPath(dependee string, changed string, opts QueryOptions) (ImportChain, error) {
+	chains, err := t.Explain(dependee, changed, 1, opts)
+	if err != nil || len(chains) == 0 {
+		return nil, err
+	}
+	return chains[0], nil
+}
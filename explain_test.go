@@ -0,0 +1,76 @@
+package pyast
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	file "github.com/nicois/file"
+)
+
+func TestExplain(t *testing.T) {
+	root, _ := filepath.Abs("testdata/forward/src")
+	trees := BuildTrees(context.Background(), file.CreatePaths(root))
+
+	a := filepath.Join(root, "app/a.py")
+	b := filepath.Join(root, "app/b.py")
+	c := filepath.Join(root, "app/c.py")
+
+	chain, err := trees.ShortestPath(a, c, QueryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ImportChain{
+		{Importer: a, Imported: b, Class: "app.b", Line: 1},
+		{Importer: b, Imported: c, Class: "app.c", Line: 1},
+	}
+	if !reflect.DeepEqual(chain, expected) {
+		t.Fatalf("Found unexpected chain:\n%v", chain)
+	}
+
+	// c does not import anything in the project
+	if chain, err := trees.ShortestPath(c, a, QueryOptions{}); err != nil || chain != nil {
+		t.Fatalf("expected no chain, got %v (%v)", chain, err)
+	}
+
+	if _, err := trees.Explain(a, "/not/in/any/tree.py", 0, QueryOptions{}); err == nil {
+		t.Fatal("expected an error for a path outside the trees")
+	}
+}
+
+func TestExplainIgnoringKinds(t *testing.T) {
+	root, _ := filepath.EvalSymlinks(t.TempDir())
+	a := filepath.Join(root, "app/a.py")
+	b := filepath.Join(root, "app/b.py")
+	c := filepath.Join(root, "app/c.py")
+	writeFile(t, filepath.Join(root, "app/__init__.py"), "")
+	writeFile(t, a, "from typing import TYPE_CHECKING\nfrom app import c\nif TYPE_CHECKING:\n    from app import b\n")
+	writeFile(t, b, "")
+	writeFile(t, c, "from app import b\n")
+	trees := BuildTrees(context.Background(), file.CreatePaths(root))
+
+	chain, err := trees.ShortestPath(a, b, QueryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (ImportChain{{Importer: a, Imported: b, Class: "app.b", Line: 4, Kind: ImportTypeChecking}}); !reflect.DeepEqual(chain, expected) {
+		t.Fatalf("Found unexpected chain:\n%v", chain)
+	}
+
+	chain, err = trees.ShortestPath(a, b, QueryOptions{Ignore: ImportTypeChecking})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ImportChain{
+		{Importer: a, Imported: c, Class: "app.c", Line: 2},
+		{Importer: c, Imported: b, Class: "app.b", Line: 1},
+	}
+	if !reflect.DeepEqual(chain, expected) {
+		t.Fatalf("Found unexpected chain:\n%v", chain)
+	}
+
+	if chain, err := trees.ShortestPath(a, b, QueryOptions{Ignore: ImportTypeChecking, MaxDepth: 1}); err != nil || chain != nil {
+		t.Fatalf("expected no chain, got %v (%v)", chain, err)
+	}
+}
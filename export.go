@@ -0,0 +1,266 @@
+package pyast
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	file "github.com/nicois/file"
+)
+
+// ExportFormat is a file format understood by Export.
+type ExportFormat string
+
+const (
+	ExportDOT     ExportFormat = "dot"     // Graphviz
+	ExportGraphML ExportFormat = "graphml" // GraphML XML
+	ExportMermaid ExportFormat = "mermaid" // a Mermaid flowchart
+	ExportJSON    ExportFormat = "json"    // see Graph
+)
+
+// ExportFormats lists every supported ExportFormat.
+var ExportFormats = []ExportFormat{ExportDOT, ExportGraphML, ExportMermaid, ExportJSON}
+
+// graphVersion identifies the JSON schema of Graph. It is incremented
+// whenever a field is removed or changes meaning.
+const graphVersion = 1
+
+// Graph is the resolved import graph, as exported in JSON.
+// Nodes and edges are sorted by path, so output is stable between runs.
+type Graph struct {
+	Version int         `json:"version"`
+	Nodes   []GraphNode `json:"nodes"`
+	Edges   []GraphEdge `json:"edges"`
+}
+
+// GraphNode is a scanned module.
+type GraphNode struct {
+	ID      string `json:"id"`   // the path, which is unique across trees
+	Root    string `json:"root"` // the python root containing the module
+	Path    string `json:"path"`
+	Class   string `json:"class"`
+	IsClass bool   `json:"isClass"` // as opposed to a node named by its filesystem path
+}
+
+// GraphEdge is an import of one module by another.
+type GraphEdge struct {
+	From     string        `json:"from"`    // ID of the importer
+	To       string        `json:"to"`      // ID of the imported module
+	Kind     string        `json:"kind"`    // a Resolution
+	Context  string        `json:"context"` // an ImportKind
+	Location GraphLocation `json:"location"`
+}
+
+// GraphLocation is where an import statement is.
+type GraphLocation struct {
+	Path string `json:"path"`
+	Line int    `json:"line"`
+}
+
+// ExportOptions limits what is exported.
+type ExportOptions struct {
+	// Around restricts the graph to these modules and those within
+	// Depth imports of them, in either direction. If empty, everything
+	// is exported.
+	Around file.Paths
+	// Depth is how many imports to follow from Around. Zero means there
+	// is no limit, which exports everything connected to them.
+	Depth int
+}
+
+// Graph returns the resolved import graph, or the subgraph selected by opts.
+func (t *trees) Graph(opts ExportOptions) Graph {
+	graph := Graph{Version: graphVersion, Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	included := t.subgraph(opts)
+	for _, tree := range *t {
+		for class := range tree.modules {
+			path := classFile(tree.root, class)
+			if !included(path) {
+				continue
+			}
+			if owner, _ := t.findModule(class); owner != path {
+				// shadowed by the same class in an earlier tree
+				continue
+			}
+			graph.Nodes = append(graph.Nodes, GraphNode{ID: path, Root: tree.root, Path: path, Class: class, IsClass: true})
+			for _, imported := range tree.resolved[class] {
+				if !included(imported.Path) {
+					continue
+				}
+				graph.Edges = append(graph.Edges, GraphEdge{
+					From:     path,
+					To:       imported.Path,
+					Kind:     imported.Resolution.String(),
+					Context:  imported.Kind.String(),
+					Location: GraphLocation{Path: path, Line: imported.Line},
+				})
+			}
+		}
+	}
+	sort.Slice(graph.Nodes, func(i, j int) bool {
+		return graph.Nodes[i].ID < graph.Nodes[j].ID
+	})
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		if graph.Edges[i].From != graph.Edges[j].From {
+			return graph.Edges[i].From < graph.Edges[j].From
+		}
+		if graph.Edges[i].To != graph.Edges[j].To {
+			return graph.Edges[i].To < graph.Edges[j].To
+		}
+		return graph.Edges[i].Context < graph.Edges[j].Context
+	})
+	return graph
+}
+
+// subgraph returns a function reporting if a path should be exported.
+func (t *trees) subgraph(opts ExportOptions) func(string) bool {
+	if len(opts.Around) == 0 {
+		return func(string) bool { return true }
+	}
+	seen := CreateClasses()
+	pending := CreateClasses()
+	for path := range opts.Around {
+		if class, ok := t.pathToClassAcrossTrees(path); ok && t.isModule(class) {
+			pending.Add(class)
+		}
+	}
+	for depth := 0; len(pending) > 0; depth++ {
+		seen.Union(pending)
+		if opts.Depth > 0 && depth >= opts.Depth {
+			break
+		}
+		nextPending := CreateClasses()
+		for class := range pending {
+			neighbours := t.getResolvedImportersAcrossTrees(class, ImportRuntime)
+			for _, imported := range t.getResolvedImportsAcrossTrees(class, ImportRuntime) {
+				neighbours.Add(imported.Imported)
+			}
+			for neighbour := range neighbours {
+				if _, already := seen[neighbour]; !already {
+					nextPending.Add(neighbour)
+				}
+			}
+		}
+		pending = nextPending
+	}
+	paths := t.resolvedPaths(seen)
+	return func(path string) bool {
+		_, ok := paths[path]
+		return ok
+	}
+}
+
+// Export writes the resolved import graph, or the subgraph selected by opts, in the given format.
+func (t *trees) Export(w io.Writer, format ExportFormat, opts ExportOptions) error {
+	graph := t.Graph(opts)
+	switch format {
+	case ExportJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(graph)
+	case ExportDOT:
+		return writeDOT(w, graph)
+	case ExportGraphML:
+		return writeGraphML(w, graph)
+	case ExportMermaid:
+		return writeMermaid(w, graph)
+	}
+	return fmt.Errorf("unknown export format %q", format)
+}
+
+// errWriter remembers the first error, so formatting code need not check every write.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) printf(format string, args ...interface{}) {
+	if e.err == nil {
+		_, e.err = fmt.Fprintf(e.w, format, args...)
+	}
+}
+
+func writeDOT(w io.Writer, graph Graph) error {
+	out := &errWriter{w: w}
+	out.printf("digraph imports {\n")
+	for _, node := range graph.Nodes {
+		out.printf("\t%v [label=%v];\n", dotQuote(node.ID), dotQuote(node.Class))
+	}
+	for _, edge := range graph.Edges {
+		style := "solid"
+		if edge.Context != ImportRuntime.String() {
+			style = "dotted"
+		} else if edge.Kind != ResolvedModule.String() {
+			style = "dashed"
+		}
+		out.printf("\t%v -> %v [label=%v, style=%v];\n", dotQuote(edge.From), dotQuote(edge.To), dotQuote(fmt.Sprintf("%v:%v", edge.Kind, edge.Location.Line)), style)
+	}
+	out.printf("}\n")
+	return out.err
+}
+
+// dotQuote quotes an ID for DOT, which only understands escaped quotes
+// and backslashes, unlike Go's %q.
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func writeGraphML(w io.Writer, graph Graph) error {
+	out := &errWriter{w: w}
+	out.printf(`<?xml version="1.0" encoding="UTF-8"?>
+<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+  <key id="root" for="node" attr.name="root" attr.type="string"/>
+  <key id="path" for="node" attr.name="path" attr.type="string"/>
+  <key id="class" for="node" attr.name="class" attr.type="string"/>
+  <key id="isClass" for="node" attr.name="isClass" attr.type="boolean"/>
+  <key id="kind" for="edge" attr.name="kind" attr.type="string"/>
+  <key id="context" for="edge" attr.name="context" attr.type="string"/>
+  <key id="line" for="edge" attr.name="line" attr.type="int"/>
+  <graph id="imports" edgedefault="directed">
+`)
+	for _, node := range graph.Nodes {
+		out.printf("    <node id=\"%v\">\n", xmlEscape(node.ID))
+		out.printf("      <data key=\"root\">%v</data>\n", xmlEscape(node.Root))
+		out.printf("      <data key=\"path\">%v</data>\n", xmlEscape(node.Path))
+		out.printf("      <data key=\"class\">%v</data>\n", xmlEscape(node.Class))
+		out.printf("      <data key=\"isClass\">%v</data>\n", node.IsClass)
+		out.printf("    </node>\n")
+	}
+	for _, edge := range graph.Edges {
+		out.printf("    <edge source=\"%v\" target=\"%v\">\n", xmlEscape(edge.From), xmlEscape(edge.To))
+		out.printf("      <data key=\"kind\">%v</data>\n", xmlEscape(edge.Kind))
+		out.printf("      <data key=\"context\">%v</data>\n", xmlEscape(edge.Context))
+		out.printf("      <data key=\"line\">%v</data>\n", edge.Location.Line)
+		out.printf("    </edge>\n")
+	}
+	out.printf("  </graph>\n</graphml>\n")
+	return out.err
+}
+
+func writeMermaid(w io.Writer, graph Graph) error {
+	// mermaid identifiers cannot contain most punctuation, so number the nodes
+	ids := make(map[string]string, len(graph.Nodes))
+	out := &errWriter{w: w}
+	out.printf("flowchart LR\n")
+	for i, node := range graph.Nodes {
+		ids[node.ID] = fmt.Sprintf("n%v", i)
+		out.printf("    %v[\"%v\"]\n", ids[node.ID], strings.ReplaceAll(node.Class, `"`, "#quot;"))
+	}
+	for _, edge := range graph.Edges {
+		arrow := "-->"
+		if edge.Kind != ResolvedModule.String() {
+			arrow = "-.->"
+		}
+		out.printf("    %v %v|%v| %v\n", ids[edge.From], arrow, edge.Kind, ids[edge.To])
+	}
+	return out.err
+}
@@ -0,0 +1,81 @@
+package pyast
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	file "github.com/nicois/file"
+)
+
+func TestExport(t *testing.T) {
+	root, _ := filepath.Abs("testdata/forward/src")
+	trees := BuildTrees(context.Background(), file.CreatePaths(root))
+	init := filepath.Join(root, "app/__init__.py")
+	a := filepath.Join(root, "app/a.py")
+	b := filepath.Join(root, "app/b.py")
+	c := filepath.Join(root, "app/c.py")
+
+	var buffer bytes.Buffer
+	if err := trees.Export(&buffer, ExportJSON, ExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	var graph Graph
+	if err := json.Unmarshal(buffer.Bytes(), &graph); err != nil {
+		t.Fatal(err)
+	}
+	if graph.Version != 1 {
+		t.Fatalf("Found unexpected version: %v", graph.Version)
+	}
+	var nodes []string
+	for _, node := range graph.Nodes {
+		if !node.IsClass {
+			t.Fatalf("Found unexpected node: %+v", node)
+		}
+		nodes = append(nodes, node.Class)
+	}
+	if expected := []string{"app.__init__", "app.a", "app.b", "app.c"}; !reflect.DeepEqual(nodes, expected) {
+		t.Fatalf("Found unexpected nodes: %v", nodes)
+	}
+	expected := []GraphEdge{
+		{From: a, To: init, Kind: "package", Context: "runtime", Location: GraphLocation{Path: a, Line: 1}},
+		{From: a, To: b, Kind: "module", Context: "runtime", Location: GraphLocation{Path: a, Line: 1}},
+		{From: b, To: init, Kind: "package", Context: "runtime", Location: GraphLocation{Path: b, Line: 1}},
+		{From: b, To: c, Kind: "module", Context: "runtime", Location: GraphLocation{Path: b, Line: 1}},
+	}
+	if !reflect.DeepEqual(graph.Edges, expected) {
+		t.Fatalf("Found unexpected edges: %+v", graph.Edges)
+	}
+
+	// only a and its direct neighbours, with every import between them
+	graph = trees.Graph(ExportOptions{Around: file.CreatePaths(a), Depth: 1})
+	if len(graph.Nodes) != 3 || len(graph.Edges) != 3 {
+		t.Fatalf("Found unexpected subgraph: %+v", graph)
+	}
+
+	for _, format := range ExportFormats {
+		buffer.Reset()
+		if err := trees.Export(&buffer, format, ExportOptions{Around: file.CreatePaths(c), Depth: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buffer.String(), "app.c") || strings.Contains(buffer.String(), "app.a") {
+			t.Fatalf("Found unexpected %v export:\n%v", format, buffer.String())
+		}
+	}
+	if err := trees.Export(&buffer, "svg", ExportOptions{}); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+
+	// DOT only escapes quotes and backslashes, unlike Go's %q
+	buffer.Reset()
+	if err := writeDOT(&buffer, Graph{Nodes: []GraphNode{{ID: `/src/café/"a\b".py`, Class: "café.x"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "digraph imports {\n\t\"/src/café/\\\"a\\\\b\\\".py\" [label=\"café.x\"];\n}\n"; buffer.String() != expected {
+		t.Fatalf("Found unexpected DOT:\n%v", buffer.String())
+	}
+}
@@ -0,0 +1,242 @@
+package pyast
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	file "github.com/nicois/file"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultTestPatterns match the test modules which pytest collects by default.
+var DefaultTestPatterns = []string{"test_*.py", "*_test.py"}
+
+// AffectedOptions controls AffectedTests.
+type AffectedOptions struct {
+	BuildTreesOptions
+
+	// Roots are the python roots to scan. If empty, they are calculated
+	// from the changed files with CalculatePythonRoots.
+	Roots file.Paths
+
+	// TestPatterns are matched against the base name of each dependee to
+	// decide if it is a test. Defaults to DefaultTestPatterns.
+	TestPatterns []string
+
+	// Ignore is the set of import kinds which are not followed.
+	Ignore ImportKind
+
+	// Canonical returns test paths with symlinks resolved.
+	Canonical bool
+
+	// Snapshot, if set, is the file the trees are loaded from and saved
+	// to, as with BuildTreesWithSnapshot.
+	Snapshot string
+}
+
+// FilterTests returns the paths whose base name matches any of the patterns,
+// using filepath.Match syntax.
+func FilterTests(paths file.Paths, patterns []string) file.Paths {
+	result := file.CreatePaths()
+	for path := range paths {
+		base := filepath.Base(path)
+		for _, pattern := range patterns {
+			if matched, _ := filepath.Match(pattern, base); matched {
+				result.Add(path)
+				break
+			}
+		}
+	}
+	return result
+}
+
+func git(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %v: %w: %v", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
+
+// ChangedFiles lists the python files which differ across revisionRange in
+// the git repository containing dir. Anything accepted by `git diff` can be
+// used, such as "main...HEAD", or a single revision to compare it with the
+// working tree. An empty range compares the working tree with HEAD.
+// Deleted files, and both sides of a rename, are included.
+func ChangedFiles(ctx context.Context, dir string, revisionRange string) (file.Paths, error) {
+	if revisionRange == "" {
+		revisionRange = "HEAD"
+	}
+	topLevel, err := git(ctx, dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	top := strings.TrimSpace(string(topLevel))
+	output, err := git(ctx, dir, "diff", "--name-status", "-z", "-M", revisionRange, "--")
+	if err != nil {
+		return nil, err
+	}
+	result := file.CreatePaths()
+	fields := strings.Split(strings.TrimSuffix(string(output), "\x00"), "\x00")
+	for i := 0; i < len(fields); i++ {
+		status := fields[i]
+		if status == "" {
+			continue
+		}
+		// renames and copies list the old and new paths; everything else has one
+		names := 1
+		if status[0] == 'R' || status[0] == 'C' {
+			names = 2
+		}
+		for ; names > 0 && i+1 < len(fields); names-- {
+			i++
+			if strings.HasSuffix(fields[i], ".py") {
+				result.Add(filepath.Join(top, fields[i]))
+			}
+		}
+	}
+	return result, nil
+}
+
+// ChangedSymbols finds the definitions changed in each python file modified
+// across revisionRange, as with ChangedFiles. Files which were added, deleted
+// or renamed are left out, as they change whole modules, as are any whose
+// diff cannot be interpreted.
+func ChangedSymbols(ctx context.Context, dir string, revisionRange string) (map[string][]DefinitionChange, error) {
+	if revisionRange == "" {
+		revisionRange = "HEAD"
+	}
+	topLevel, err := git(ctx, dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	top := strings.TrimSpace(string(topLevel))
+	// with enough context, the diff contains the whole of each new file
+	output, err := git(ctx, dir, "-c", "core.quotePath=false", "diff", "--no-color", "--no-ext-diff", "--diff-filter=M", "--unified=1000000", revisionRange, "--", "*.py")
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]DefinitionChange)
+	var path string
+	var diff, content strings.Builder
+	inHunks := false
+	flush := func() {
+		if path != "" && strings.HasSuffix(path, ".py") {
+			if changes, err := ChangedDefinitionsInDiff(content.String(), diff.String()); err != nil {
+				log.Debugf("While reading the diff of %v: %v", path, err)
+			} else {
+				result[path] = changes
+			}
+		}
+		path = ""
+		diff.Reset()
+		content.Reset()
+		inHunks = false
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			flush()
+		case !inHunks && strings.HasPrefix(line, "+++ "):
+			if name, ok := diffPath(line[len("+++ "):]); ok {
+				path = filepath.Join(top, name)
+			}
+		case strings.HasPrefix(line, "@@"):
+			inHunks = true
+		}
+		if !inHunks {
+			continue
+		}
+		diff.WriteString(line + "\n")
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "+") {
+			content.WriteString(line[1:] + "\n")
+		}
+	}
+	flush()
+	return result, nil
+}
+
+// diffPath reads the path of the new file from the +++ line of a diff,
+// which git quotes if it contains unusual characters, such as quotes, and
+// follows with a tab if it contains a space.
+func diffPath(name string) (string, bool) {
+	// a tab within the name would have been quoted
+	name = strings.TrimSuffix(name, "\t")
+	if strings.HasPrefix(name, `"`) {
+		unquoted, err := strconv.Unquote(name)
+		if err != nil {
+			return "", false
+		}
+		name = unquoted
+	}
+	return strings.CutPrefix(name, "b/")
+}
+
+// AffectedTests selects the test files which should be run after the changes
+// in revisionRange: those which import a changed file, directly or indirectly.
+// Deleted files still select the modules which import them. If opts.Symbols
+// is set, modified files only select the tests which depend on the
+// definitions which changed, as with GetDependeesOfSymbols. As with
+// BuildTreesWithOptions, a *BuildError may be returned alongside the result.
+func AffectedTests(ctx context.Context, dir string, revisionRange string, opts AffectedOptions) (file.Paths, error) {
+	changed, err := ChangedFiles(ctx, dir, revisionRange)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Changed python files: %v", changed)
+	roots := opts.Roots
+	if len(roots) == 0 {
+		// a deleted file can only tell us its root if its directory remains
+		existing := file.CreatePaths()
+		for path := range changed {
+			if file.DirExists(filepath.Dir(path)) {
+				existing.Add(path)
+			}
+		}
+		if roots, err = CalculatePythonRoots(existing); err != nil {
+			return nil, err
+		}
+	}
+	trees, buildErr := BuildTreesWithSnapshot(ctx, opts.Snapshot, roots, opts.BuildTreesOptions)
+	if trees == nil {
+		return nil, buildErr
+	}
+	var symbols []Symbol
+	if opts.Symbols {
+		definitions, err := ChangedSymbols(ctx, dir, revisionRange)
+		if err != nil {
+			return nil, err
+		}
+		whole := file.CreatePaths()
+		for path := range changed {
+			if _, ok := definitions[path]; !ok {
+				whole.Add(path)
+			}
+		}
+		changed = whole
+		for path, changes := range definitions {
+			for _, change := range changes {
+				symbols = append(symbols, change.Symbol(path))
+			}
+		}
+		log.Debugf("Changed symbols: %v", symbols)
+	}
+	dependees, err := trees.getDependeesOfChanges(changed, symbols, QueryOptions{Ignore: opts.Ignore, Canonical: opts.Canonical})
+	if err != nil {
+		return nil, err
+	}
+	patterns := opts.TestPatterns
+	if len(patterns) == 0 {
+		patterns = DefaultTestPatterns
+	}
+	return FilterTests(dependees, patterns), buildErr
+}
@@ -0,0 +1,188 @@
+package pyast
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	file "github.com/nicois/file"
+)
+
+func TestAffectedTests(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+	repo := t.TempDir()
+	ctx := context.Background()
+	write := func(name string, content string) {
+		path := filepath.Join(repo, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	commit := func(message string) {
+		for _, args := range [][]string{{"add", "-A"}, {"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-qm", message}} {
+			if _, err := git(ctx, repo, args...); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := git(ctx, repo, "init", "-q"); err != nil {
+		t.Fatal(err)
+	}
+	write("src/shop/__init__.py", "")
+	write("src/shop/models.py", "class Model: pass\n")
+	write("src/shop/legacy.py", "def old(): pass\n")
+	write("src/shop/views.py", "from shop.models import Model\n")
+	write("src/shop/test_views.py", "import shop.views\n")
+	write("src/shop/test_legacy.py", "from shop import legacy\n")
+	write("src/shop/models_test.py", "import shop.models\n")
+	write("src/shop/test_unrelated.py", "import os\n")
+	commit("initial")
+	if _, err := git(ctx, repo, "branch", "base"); err != nil {
+		t.Fatal(err)
+	}
+	write("src/shop/models.py", "class Model:\n    pass\n")
+	if err := os.Remove(filepath.Join(repo, "src/shop/legacy.py")); err != nil {
+		t.Fatal(err)
+	}
+	commit("change")
+
+	top, _ := filepath.EvalSymlinks(repo)
+	changed, err := ChangedFiles(ctx, repo, "base...HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := file.CreatePaths(filepath.Join(top, "src/shop/models.py"), filepath.Join(top, "src/shop/legacy.py")); !reflect.DeepEqual(changed, expected) {
+		t.Fatalf("Found unexpected changes:\n%v", changed)
+	}
+
+	tests, err := AffectedTests(ctx, repo, "base...HEAD", AffectedOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := file.CreatePaths(
+		filepath.Join(top, "src/shop/test_views.py"),
+		filepath.Join(top, "src/shop/test_legacy.py"),
+		filepath.Join(top, "src/shop/models_test.py"),
+	)
+	if !reflect.DeepEqual(tests, expected) {
+		t.Fatalf("Found unexpected tests:\n%v", tests)
+	}
+}
+
+func TestAffectedTestsWithSymbols(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+	repo, _ := filepath.EvalSymlinks(t.TempDir())
+	ctx := context.Background()
+	commit := func(message string) {
+		for _, args := range [][]string{{"add", "-A"}, {"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-qm", message}} {
+			if _, err := git(ctx, repo, args...); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := git(ctx, repo, "init", "-q"); err != nil {
+		t.Fatal(err)
+	}
+	path := func(name string) string {
+		return filepath.Join(repo, "src/shop", name)
+	}
+	writeFile(t, path("__init__.py"), "")
+	writeFile(t, path("utils.py"), "def slugify(value):\n    return value\n\ndef titlecase(value):\n    return value\n")
+	writeFile(t, path("legacy.py"), "def old(): pass\n")
+	writeFile(t, path("test_slug.py"), "from shop.utils import slugify\n")
+	writeFile(t, path("test_title.py"), "from shop.utils import titlecase\n")
+	writeFile(t, path("test_legacy.py"), "from shop import legacy\n")
+	commit("initial")
+	writeFile(t, path("utils.py"), "def slugify(value):\n    return value.lower()\n\ndef titlecase(value):\n    return value\n")
+	if err := os.Remove(path("legacy.py")); err != nil {
+		t.Fatal(err)
+	}
+
+	symbols, err := ChangedSymbols(ctx, repo, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string][]DefinitionChange{path("utils.py"): {{Name: "slugify", Change: DefinitionModified}}}; !reflect.DeepEqual(symbols, expected) {
+		t.Fatalf("Found unexpected symbols: %+v", symbols)
+	}
+
+	tests, err := AffectedTests(ctx, repo, "", AffectedOptions{BuildTreesOptions: BuildTreesOptions{Symbols: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := file.CreatePaths(path("test_slug.py"), path("test_legacy.py")); !reflect.DeepEqual(tests, expected) {
+		t.Fatalf("Found unexpected tests:\n%v", tests)
+	}
+}
+
+func TestChangedSymbolsOfSubscriptAssignment(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+	repo, _ := filepath.EvalSymlinks(t.TempDir())
+	ctx := context.Background()
+	if _, err := git(ctx, repo, "init", "-q"); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(repo, "handlers.py")
+	writeFile(t, path, "registry = {}\nkey = \"a\"\nregistry[key] = 1\n")
+	for _, args := range [][]string{{"add", "-A"}, {"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-qm", "initial"}} {
+		if _, err := git(ctx, repo, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, path, "registry = {}\nkey = \"a\"\nregistry[key] = 2\n")
+
+	// top-level code rather than a definition of key
+	symbols, err := ChangedSymbols(ctx, repo, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string][]DefinitionChange{path: {{Name: "", Change: DefinitionModified}}}; !reflect.DeepEqual(symbols, expected) {
+		t.Fatalf("Found unexpected symbols: %+v", symbols)
+	}
+}
+
+func TestChangedSymbolsWithUnusualPaths(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+	repo, _ := filepath.EvalSymlinks(t.TempDir())
+	ctx := context.Background()
+	if _, err := git(ctx, repo, "init", "-q"); err != nil {
+		t.Fatal(err)
+	}
+	// git quotes non-ASCII names by default, and always those with quotes,
+	// and follows names containing spaces with a tab
+	names := []string{"café.py", "with space.py", `with "quotes".py`}
+	for _, name := range names {
+		writeFile(t, filepath.Join(repo, name), "def f():\n    return 1\n")
+	}
+	for _, args := range [][]string{{"config", "core.quotePath", "true"}, {"add", "-A"}, {"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-qm", "initial"}} {
+		if _, err := git(ctx, repo, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := make(map[string][]DefinitionChange)
+	for _, name := range names {
+		writeFile(t, filepath.Join(repo, name), "def f():\n    return 2\n")
+		expected[filepath.Join(repo, name)] = []DefinitionChange{{Name: "f", Change: DefinitionModified}}
+	}
+	symbols, err := ChangedSymbols(ctx, repo, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(symbols, expected) {
+		t.Fatalf("Found unexpected symbols: %+v", symbols)
+	}
+}
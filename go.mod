@@ -5,16 +5,17 @@ go 1.21.7
 toolchain go1.22.2
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/getsentry/sentry-go v0.27.0
 	github.com/nicois/cache v0.0.0-20240516234140-d031cf970c18
 	github.com/nicois/file v0.0.0-20240109220158-74095eea75b9
 	github.com/sirupsen/logrus v1.9.3
 	golang.org/x/sync v0.7.0
+	golang.org/x/sys v0.6.0
+	golang.org/x/text v0.8.0
 )
 
 require (
 	github.com/mattn/go-sqlite3 v1.14.22 // indirect
 	github.com/nicois/fastdb v0.0.0-20240511060213-776b25c4dbb9 // indirect
-	golang.org/x/sys v0.6.0 // indirect
-	golang.org/x/text v0.8.0 // indirect
 )
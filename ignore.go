@@ -0,0 +1,225 @@
+package pyast
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ignoreRule is a single pattern from a .gitignore file, or from
+// BuildTreesOptions.Exclude.
+type ignoreRule struct {
+	base     string         // the directory the pattern is relative to
+	pattern  *regexp.Regexp // matches the path relative to base if anchored, otherwise the base name
+	anchored bool
+	negate   bool
+	dirOnly  bool
+}
+
+// parseIgnoreRules reads patterns in .gitignore syntax. Patterns containing
+// a / (other than at the end) are relative to base; others match the base
+// name of a file or directory at any depth.
+func parseIgnoreRules(base string, content string) []ignoreRule {
+	var result []ignoreRule
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		// trailing spaces are ignored unless escaped
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+			line = line[:len(line)-1]
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{base: base}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		pattern, err := regexp.Compile(globRegexp(line))
+		if err != nil {
+			continue
+		}
+		rule.pattern = pattern
+		result = append(result, rule)
+	}
+	return result
+}
+
+// globRegexp converts a .gitignore glob into an equivalent regular expression.
+func globRegexp(glob string) string {
+	var result strings.Builder
+	result.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**") && (i == 0 || glob[i-1] == '/') && (i+2 == len(glob) || glob[i+2] == '/') {
+				if i+2 == len(glob) {
+					// everything inside
+					result.WriteString(".*")
+					i++
+				} else {
+					// zero or more directories
+					result.WriteString("(.*/)?")
+					i += 2
+				}
+				continue
+			}
+			result.WriteString("[^/]*")
+			for i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+			}
+		case '?':
+			result.WriteString("[^/]")
+		case '[':
+			end := i + 1
+			if end < len(glob) && (glob[end] == '!' || glob[end] == '^') {
+				end++
+			}
+			if end < len(glob) && glob[end] == ']' {
+				end++
+			}
+			for end < len(glob) && glob[end] != ']' {
+				end++
+			}
+			if end >= len(glob) {
+				result.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			result.WriteString("[" + strings.ReplaceAll(class, "[", `\[`) + "]")
+			i = end
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				result.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			}
+		default:
+			result.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	result.WriteString("$")
+	return result.String()
+}
+
+func (r ignoreRule) matches(path string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !r.anchored {
+		return r.pattern.MatchString(filepath.Base(path))
+	}
+	var relative string
+	if r.base == "/" {
+		relative = strings.TrimPrefix(path, "/")
+	} else if strings.HasPrefix(path, r.base+"/") {
+		relative = path[len(r.base)+1:]
+	} else {
+		return false
+	}
+	return r.pattern.MatchString(relative)
+}
+
+// matchRules reports if the last rule matching path excludes it.
+func matchRules(rules []ignoreRule, path string, isDir bool) bool {
+	result := false
+	for _, rule := range rules {
+		if rule.matches(path, isDir) {
+			result = !rule.negate
+		}
+	}
+	return result
+}
+
+// ignorer decides which files and directories beneath a root are not scanned.
+type ignorer struct {
+	exclude   []ignoreRule
+	gitignore bool
+	top       string // the top of the git repository, or the root if it is not in one
+
+	mutex *sync.Mutex
+	// dirs are the .gitignore rules which apply to the entries of each directory
+	dirs map[string][]ignoreRule
+}
+
+func newIgnorer(root string, opts BuildTreesOptions) *ignorer {
+	result := &ignorer{gitignore: !opts.NoGitignore, top: root, mutex: new(sync.Mutex), dirs: make(map[string][]ignoreRule)}
+	for _, pattern := range opts.Exclude {
+		result.exclude = append(result.exclude, parseIgnoreRules("/", pattern)...)
+	}
+	if result.gitignore {
+		for dir := root; ; dir = filepath.Dir(dir) {
+			if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+				result.top = dir
+				break
+			}
+			if dir == filepath.Dir(dir) {
+				break
+			}
+		}
+	}
+	return result
+}
+
+// ignores reports if path should not be scanned.
+func (i *ignorer) ignores(path string, isDir bool) bool {
+	if matchRules(i.exclude, path, isDir) {
+		return true
+	}
+	if !i.gitignore {
+		return false
+	}
+	if isDir && filepath.Base(path) == ".git" {
+		return true
+	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	return matchRules(i.rules(filepath.Dir(path)), path, isDir)
+}
+
+// rules finds the .gitignore rules applying within dir: those in
+// .git/info/exclude, followed by those in the .gitignore files of dir and
+// each directory above it, outermost first. The mutex must be held.
+func (i *ignorer) rules(dir string) []ignoreRule {
+	if rules, ok := i.dirs[dir]; ok {
+		return rules
+	}
+	var rules []ignoreRule
+	switch {
+	case dir == i.top:
+		rules = readIgnoreRules(i.top, filepath.Join(i.top, ".git", "info", "exclude"))
+	case strings.HasPrefix(dir, i.top+"/"):
+		rules = i.rules(filepath.Dir(dir))
+	default:
+		return nil
+	}
+	if own := readIgnoreRules(dir, filepath.Join(dir, ".gitignore")); len(own) > 0 {
+		// copy, so that the parent's rules are not modified
+		rules = append(rules[:len(rules):len(rules)], own...)
+	}
+	i.dirs[dir] = rules
+	return rules
+}
+
+func readIgnoreRules(base string, path string) []ignoreRule {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return parseIgnoreRules(base, string(content))
+}
@@ -0,0 +1,95 @@
+package pyast
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	file "github.com/nicois/file"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	rules := parseIgnoreRules("/repo", `# comment
+*.pyc
+build/
+/top.py
+docs/**/*.py
+**/migrations/m*.py
+!keep.pyc
+\#hash.py
+`)
+	for _, test := range []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"/repo/a/b.pyc", false, true},
+		{"/repo/a/keep.pyc", false, false},
+		{"/repo/a/build", true, true},
+		{"/repo/a/build", false, false},
+		{"/repo/top.py", false, true},
+		{"/repo/a/top.py", false, false},
+		{"/repo/docs/conf.py", false, true},
+		{"/repo/docs/a/b/conf.py", false, true},
+		{"/repo/a/docs/conf.py", false, false},
+		{"/repo/migrations/m0001.py", false, true},
+		{"/repo/a/b/migrations/m0001.py", false, true},
+		{"/repo/a/b/migrations/__init__.py", false, false},
+		{"/repo/#hash.py", false, true},
+		{"/other/top.py", false, false},
+	} {
+		if ignored := matchRules(rules, test.path, test.isDir); ignored != test.ignored {
+			t.Fatalf("Found unexpected result for %v: %v", test.path, ignored)
+		}
+	}
+}
+
+func TestBuildTreesHonoursGitignore(t *testing.T) {
+	repo, _ := filepath.EvalSymlinks(t.TempDir())
+	if err := os.MkdirAll(filepath.Join(repo, ".git/info"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(repo, ".git/info/exclude"), "scratch.py\n")
+	writeFile(t, filepath.Join(repo, ".gitignore"), ".venv/\nbuild/\ngenerated_*.py\n")
+	root := filepath.Join(repo, "src")
+	writeFile(t, filepath.Join(root, "app/__init__.py"), "")
+	writeFile(t, filepath.Join(root, "app/models.py"), "")
+	writeFile(t, filepath.Join(root, "app/scratch.py"), "import app.models\n")
+	writeFile(t, filepath.Join(root, "app/build/__init__.py"), "import app.models\n")
+	writeFile(t, filepath.Join(root, "app/generated_api.py"), "import app.models\n")
+	writeFile(t, filepath.Join(root, "app/generated_kept.py"), "import app.models\n")
+	writeFile(t, filepath.Join(root, "app/.gitignore"), "!generated_kept.py\n")
+	writeFile(t, filepath.Join(root, "app/migrations/__init__.py"), "")
+	writeFile(t, filepath.Join(root, "app/migrations/m0001.py"), "import app.models\n")
+	writeFile(t, filepath.Join(root, ".venv/lib/app/__init__.py"), "import app.models\n")
+
+	models := filepath.Join(root, "app/models.py")
+	query := func(opts BuildTreesOptions) file.Paths {
+		t.Helper()
+		trees, err := BuildTreesWithOptions(context.Background(), file.CreatePaths(root), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dependees, err := trees.GetDependees(file.CreatePaths(models))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dependees
+	}
+
+	expected := file.CreatePaths(models, filepath.Join(root, "app/generated_kept.py"), filepath.Join(root, "app/migrations/m0001.py"))
+	if dependees := query(BuildTreesOptions{NamespacePackages: true}); !reflect.DeepEqual(dependees, expected) {
+		t.Fatalf("Found unexpected dependees: %v", dependees)
+	}
+
+	expected = file.CreatePaths(models, filepath.Join(root, "app/generated_kept.py"))
+	if dependees := query(BuildTreesOptions{NamespacePackages: true, Exclude: []string{"**/migrations/m*.py"}}); !reflect.DeepEqual(dependees, expected) {
+		t.Fatalf("Found unexpected dependees: %v", dependees)
+	}
+
+	if dependees := query(BuildTreesOptions{NamespacePackages: true, NoGitignore: true}); len(dependees) != 7 {
+		t.Fatalf("Found unexpected dependees: %v", dependees)
+	}
+}
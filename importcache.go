@@ -0,0 +1,155 @@
+package pyast
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/nicois/cache"
+	log "github.com/sirupsen/logrus"
+)
+
+// ImportCache stores what was found in each file, so that files which have
+// not changed need not be parsed again. Keys are hex-encoded hashes of
+// everything the value depends on.
+type ImportCache interface {
+	// Cache returns the value stored for key, or stores and returns the
+	// result of compute if there is none. Values are not stored if
+	// compute returns an error.
+	Cache(ctx context.Context, key string, compute func() ([]byte, error)) ([]byte, error)
+}
+
+// NoCache parses every file every time, for when nothing can be written.
+var NoCache ImportCache = noCache{}
+
+type noCache struct{}
+
+func (noCache) Cache(ctx context.Context, key string, compute func() ([]byte, error)) ([]byte, error) {
+	return compute()
+}
+
+// NewMemoryCache creates an ImportCache which lasts as long as the process.
+func NewMemoryCache() ImportCache {
+	return &memoryCache{mutex: new(sync.RWMutex), values: make(map[string][]byte)}
+}
+
+type memoryCache struct {
+	mutex  *sync.RWMutex
+	values map[string][]byte
+}
+
+func (c *memoryCache) Cache(ctx context.Context, key string, compute func() ([]byte, error)) ([]byte, error) {
+	c.mutex.RLock()
+	value, ok := c.values[key]
+	c.mutex.RUnlock()
+	if ok {
+		return value, nil
+	}
+	value, err := compute()
+	if err != nil {
+		return value, err
+	}
+	c.mutex.Lock()
+	c.values[key] = value
+	c.mutex.Unlock()
+	return value, nil
+}
+
+// NewDirectoryCache creates an ImportCache storing a file per key beneath
+// dir, which is created when needed. As keys do not depend on where files
+// are, the directory can be shared, for example between CI runs. If the
+// directory cannot be written, values are computed without being stored.
+func NewDirectoryCache(dir string) ImportCache {
+	return directoryCache{dir: dir}
+}
+
+type directoryCache struct {
+	dir string
+}
+
+func (c directoryCache) path(key string) string {
+	if len(key) < 3 {
+		return filepath.Join(c.dir, key)
+	}
+	return filepath.Join(c.dir, key[:2], key[2:])
+}
+
+func (c directoryCache) Cache(ctx context.Context, key string, compute func() ([]byte, error)) ([]byte, error) {
+	path := c.path(key)
+	value, err := os.ReadFile(path)
+	if err == nil {
+		return value, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		log.Debugf("While reading %v from the cache: %v", key, err)
+	}
+	if value, err = compute(); err != nil {
+		return value, err
+	}
+	if err := c.put(path, value); err != nil {
+		log.Debugf("Could not cache %v: %v", key, err)
+	}
+	return value, nil
+}
+
+// put writes the value without readers seeing a partial file.
+func (c directoryCache) put(path string, value []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(value); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// NewSQLiteCache creates an ImportCache in an sqlite database named name,
+// in ~/.cache. Values expire after a day. This is the default.
+func NewSQLiteCache(ctx context.Context, name string) (ImportCache, error) {
+	cacher, err := cache.Create[string](ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return sqliteCache{cacher: cacher}, nil
+}
+
+type sqliteCache struct {
+	cacher cache.Cacher[string]
+}
+
+func (c sqliteCache) Cache(ctx context.Context, key string, compute func() ([]byte, error)) ([]byte, error) {
+	hasher := sha256.New()
+	hasher.Write([]byte(key))
+	return c.cacher.Cache(ctx, hasher, func(ctx context.Context, stdout io.Writer, stderr io.Writer) ([]byte, error) {
+		return compute()
+	}, cache.CreateStaticListener(""))
+}
+
+// ParseImportCache interprets the name of an ImportCache: "none" is NoCache,
+// "memory" is a new memory cache, "" or "default" is nil (meaning the
+// default), and anything else is the directory of a directory cache.
+func ParseImportCache(name string) ImportCache {
+	switch name {
+	case "", "default":
+		return nil
+	case "none":
+		return NoCache
+	case "memory":
+		return NewMemoryCache()
+	}
+	return NewDirectoryCache(name)
+}
@@ -0,0 +1,98 @@
+package pyast
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	file "github.com/nicois/file"
+)
+
+func TestImportCaches(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	for name, c := range map[string]ImportCache{"memory": NewMemoryCache(), "directory": NewDirectoryCache(filepath.Join(dir, "cache"))} {
+		computed := 0
+		compute := func() ([]byte, error) {
+			computed++
+			return []byte("value"), nil
+		}
+		fail := func() ([]byte, error) {
+			computed++
+			return nil, errors.New("failed")
+		}
+		for i := 0; i < 2; i++ {
+			if value, err := c.Cache(ctx, "abcdef", compute); err != nil || string(value) != "value" {
+				t.Fatalf("Found unexpected %v value: %q %v", name, value, err)
+			}
+			if _, err := c.Cache(ctx, "012345", fail); err == nil {
+				t.Fatalf("expected an error from the %v cache", name)
+			}
+		}
+		if computed != 3 {
+			t.Fatalf("Found unexpected %v computations: %v", name, computed)
+		}
+	}
+
+	computed := 0
+	for i := 0; i < 2; i++ {
+		NoCache.Cache(ctx, "abcdef", func() ([]byte, error) {
+			computed++
+			return nil, nil
+		})
+	}
+	if computed != 2 {
+		t.Fatalf("Found unexpected computations: %v", computed)
+	}
+
+	// a cache which cannot be written still works
+	readOnly := filepath.Join(dir, "read-only")
+	writeFile(t, readOnly, "")
+	if value, err := NewDirectoryCache(readOnly).Cache(ctx, "abcdef", func() ([]byte, error) { return []byte("value"), nil }); err != nil || string(value) != "value" {
+		t.Fatalf("Found unexpected value: %q %v", value, err)
+	}
+
+	if reflect.TypeOf(ParseImportCache("none")) != reflect.TypeOf(NoCache) || ParseImportCache("default") != nil || reflect.TypeOf(ParseImportCache("/tmp/x")) != reflect.TypeOf(directoryCache{}) {
+		t.Fatal("Found unexpected caches")
+	}
+}
+
+// countingCache counts the values which were not already cached.
+type countingCache struct {
+	ImportCache
+	mutex    sync.Mutex
+	computed int
+}
+
+func (c *countingCache) Cache(ctx context.Context, key string, compute func() ([]byte, error)) ([]byte, error) {
+	return c.ImportCache.Cache(ctx, key, func() ([]byte, error) {
+		c.mutex.Lock()
+		c.computed++
+		c.mutex.Unlock()
+		return compute()
+	})
+}
+
+func TestDirectoryCacheIsSharedBetweenCheckouts(t *testing.T) {
+	cache := &countingCache{ImportCache: NewDirectoryCache(filepath.Join(t.TempDir(), "cache"))}
+	for _, checkout := range []string{t.TempDir(), t.TempDir()} {
+		root, _ := filepath.EvalSymlinks(checkout)
+		writeFile(t, filepath.Join(root, "app/__init__.py"), "")
+		writeFile(t, filepath.Join(root, "app/models.py"), "")
+		writeFile(t, filepath.Join(root, "app/views.py"), "from . import models\n")
+		trees, err := BuildTreesWithOptions(context.Background(), file.CreatePaths(root), BuildTreesOptions{Cache: cache})
+		if err != nil {
+			t.Fatal(err)
+		}
+		dependees, _ := trees.GetDependees(file.CreatePaths(filepath.Join(root, "app/models.py")))
+		if expected := file.CreatePaths(filepath.Join(root, "app/models.py"), filepath.Join(root, "app/views.py")); !reflect.DeepEqual(dependees, expected) {
+			t.Fatalf("Found unexpected dependees: %v", dependees)
+		}
+	}
+	if cache.computed != 3 {
+		t.Fatalf("Found unexpected computations: %v", cache.computed)
+	}
+}
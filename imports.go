@@ -0,0 +1,208 @@
+package pyast
+
+import (
+	"strings"
+)
+
+// importStatement is a single `import ...` or `from ... import ...` statement.
+type importStatement struct {
+	from   bool     // true for `from x import y`
+	module string   // the module after `from`, without any leading dots
+	level  int      // the number of leading dots in a relative import
+	names  []string // the imported names (dotted module paths for plain imports)
+	alias  []string // what each name is imported as, or ""
+	line   int
+	kind   ImportKind
+
+	// relativeToName is set if a relative import is relative to the module's
+	// __name__ rather than its package, as in import_module(".x", package=__name__)
+	relativeToName bool
+}
+
+// logicalStatements splits a token stream into simple statements: logical
+// lines are split on `;`, and the body of a compound statement which
+// shares its line (eg `try: import foo`) is treated as its own statement.
+// Comments, NL, INDENT and DEDENT tokens are dropped.
+func logicalStatements(tokens []token) [][]token {
+	var result [][]token
+	var current []token
+	depth := 0
+	flush := func() {
+		if len(current) > 0 {
+			result = append(result, current)
+		}
+		current = nil
+		depth = 0
+	}
+	for i, tok := range tokens {
+		switch tok.kind {
+		case tokenComment, tokenNL, tokenIndent, tokenDedent:
+			continue
+		case tokenNewline, tokenEndMarker:
+			flush()
+			continue
+		case tokenOp:
+			switch tok.value {
+			case "(", "[", "{":
+				depth++
+			case ")", "]", "}":
+				depth--
+			case ";":
+				if depth <= 0 {
+					flush()
+					continue
+				}
+			case ":":
+				if depth <= 0 && i+1 < len(tokens) && (tokens[i+1].is(tokenName, "import") || tokens[i+1].is(tokenName, "from")) {
+					current = append(current, tok)
+					flush()
+					continue
+				}
+			}
+		}
+		current = append(current, tok)
+	}
+	flush()
+	return result
+}
+
+// parseImportStatement interprets a simple statement, returning false if
+// it is not an import.
+func parseImportStatement(statement []token) (importStatement, bool) {
+	if len(statement) < 2 || statement[0].kind != tokenName {
+		return importStatement{}, false
+	}
+	result := importStatement{line: statement[0].line}
+	rest := statement[1:]
+	switch statement[0].value {
+	case "import":
+		for len(rest) > 0 {
+			name, remaining := parseDottedName(rest)
+			if name == "" {
+				break
+			}
+			result.names = append(result.names, name)
+			rest = result.skipAlias(remaining)
+			if len(rest) == 0 || !rest[0].is(tokenOp, ",") {
+				break
+			}
+			rest = rest[1:]
+		}
+	case "from":
+		result.from = true
+		for len(rest) > 0 && rest[0].kind == tokenOp && (rest[0].value == "." || rest[0].value == "...") {
+			result.level += len(rest[0].value)
+			rest = rest[1:]
+		}
+		result.module, rest = parseDottedName(rest)
+		if len(rest) == 0 || !rest[0].is(tokenName, "import") {
+			return importStatement{}, false
+		}
+		rest = rest[1:]
+		if len(rest) > 0 && rest[0].is(tokenOp, "(") {
+			rest = rest[1:]
+		}
+		for len(rest) > 0 {
+			if rest[0].is(tokenOp, "*") {
+				result.names = append(result.names, "*")
+				result.alias = append(result.alias, "")
+				break
+			}
+			if rest[0].kind != tokenName {
+				break
+			}
+			result.names = append(result.names, rest[0].value)
+			rest = result.skipAlias(rest[1:])
+			if len(rest) == 0 || !rest[0].is(tokenOp, ",") {
+				break
+			}
+			rest = rest[1:]
+		}
+	default:
+		return importStatement{}, false
+	}
+	if len(result.names) == 0 {
+		return importStatement{}, false
+	}
+	return result, true
+}
+
+func parseDottedName(tokens []token) (string, []token) {
+	var parts []string
+	for len(tokens) > 0 && tokens[0].kind == tokenName {
+		if len(parts) == 0 && tokens[0].value == "import" {
+			// `from . import foo` has no module name
+			break
+		}
+		parts = append(parts, tokens[0].value)
+		tokens = tokens[1:]
+		if len(tokens) < 2 || !tokens[0].is(tokenOp, ".") {
+			break
+		}
+		tokens = tokens[1:]
+	}
+	return strings.Join(parts, "."), tokens
+}
+
+// skipAlias records the alias of the last name, if there is one.
+func (s *importStatement) skipAlias(tokens []token) []token {
+	alias := ""
+	if len(tokens) >= 2 && tokens[0].is(tokenName, "as") && tokens[1].kind == tokenName {
+		alias = tokens[1].value
+		tokens = tokens[2:]
+	}
+	s.alias = append(s.alias, alias)
+	return tokens
+}
+
+// parseImports finds all the import statements in python source.
+func parseImports(content string) []importStatement {
+	return parseImportsAndReferences(content, parseOptions{})
+}
+
+// parseImportsAndReferences finds all the import statements in python source,
+// along with anything else which the options treat as an import.
+func parseImportsAndReferences(content string, parsing parseOptions) []importStatement {
+	result, _ := parseModule(content, parsing)
+	return result
+}
+
+// parseModule is parseImportsAndReferences, also finding the module's symbols.
+func parseModule(content string, parsing parseOptions) ([]importStatement, moduleSymbols) {
+	var result []importStatement
+	statements := logicalStatements(tokenize(content))
+	kinds := statementKinds(statements)
+	parsed := make([]*importStatement, len(statements))
+	var imports []importStatement
+	for i, statement := range statements {
+		if s, ok := parseImportStatement(statement); ok {
+			parsed[i] = &s
+			imports = append(imports, s)
+		}
+	}
+	finders := specFinders(imports)
+	for i, statement := range statements {
+		if s := parsed[i]; s != nil {
+			s.kind = kinds[i]
+			result = append(result, *s)
+			continue
+		}
+		for _, s := range parseDynamicImports(statement, finders) {
+			s.kind |= kinds[i]
+			result = append(result, s)
+		}
+		if parsing.StringReferences != nil {
+			for _, s := range parsing.StringReferences.parse(statement) {
+				s.kind |= kinds[i]
+				result = append(result, s)
+			}
+		}
+		if parsing.PytestPlugins {
+			for _, s := range parsePytestPlugins(statement) {
+				s.kind |= kinds[i]
+				result = append(result, s)
+			}
+		}
+	}
+	return result, parseSymbols(statements, parsing.Symbols)
+}
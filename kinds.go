@@ -0,0 +1,209 @@
+package pyast
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ImportKind describes when an import statement is executed. An import may
+// be of several kinds at once, such as a fallback within a function.
+type ImportKind int
+
+const (
+	// ImportRuntime imports are executed whenever the module is imported.
+	ImportRuntime ImportKind = 0
+	// ImportTypeChecking imports are within `if TYPE_CHECKING:`, so are never executed.
+	ImportTypeChecking ImportKind = 1 << (iota - 1)
+	// ImportFunctionLocal imports are within a function, so are only executed when it is called.
+	ImportFunctionLocal
+	// ImportFallback imports are within a try statement which handles ImportError.
+	ImportFallback
+	// ImportVersionConditional imports are within an `if sys.version_info` branch.
+	ImportVersionConditional
+	// ImportDynamic imports are made by calling importlib.import_module,
+	// __import__ or importlib.util.find_spec with a literal module name.
+	ImportDynamic
+	// ImportStringRef references are dotted paths in string literals, such as
+	// mock.patch targets. They are only found if BuildTreesOptions.StringReferences is set.
+	ImportStringRef
+	// ImportImplicit dependencies are not import statements, such as a test
+	// module's dependency on the conftest.py files which pytest loads for it.
+	ImportImplicit
+
+	// AllImportKinds is every kind except ImportRuntime.
+	AllImportKinds = ImportTypeChecking | ImportFunctionLocal | ImportFallback | ImportVersionConditional | ImportDynamic | ImportStringRef | ImportImplicit
+)
+
+var importKindNames = []struct {
+	kind ImportKind
+	name string
+}{
+	{ImportTypeChecking, "type-checking"},
+	{ImportFunctionLocal, "function-local"},
+	{ImportFallback, "fallback"},
+	{ImportVersionConditional, "version-conditional"},
+	{ImportDynamic, "dynamic"},
+	{ImportStringRef, "string-ref"},
+	{ImportImplicit, "implicit"},
+}
+
+func (k ImportKind) String() string {
+	if k == ImportRuntime {
+		return "runtime"
+	}
+	var names []string
+	for _, n := range importKindNames {
+		if k&n.kind != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseImportKind is the inverse of ImportKind.String.
+func ParseImportKind(s string) (ImportKind, error) {
+	var result ImportKind
+	for _, name := range strings.Split(s, ",") {
+		found := false
+		for _, n := range importKindNames {
+			if n.name == name {
+				result |= n.kind
+				found = true
+			}
+		}
+		if !found && name != "runtime" {
+			return 0, fmt.Errorf("unknown import kind %q", name)
+		}
+	}
+	return result, nil
+}
+
+// ignores reports if an import of the given kind should not be followed,
+// when k is the set of kinds to ignore.
+func (k ImportKind) ignores(kind ImportKind) bool {
+	return kind&k != 0
+}
+
+// block is the body of a clause of a compound statement, eg `if x:` or `try:`.
+type block struct {
+	column  int        // of the clause's keyword
+	keyword string     // eg "if", "except", "def"
+	kind    ImportKind // what this clause alone implies
+	group   *blockGroup
+	inGroup bool // if group.kind applies to this clause
+}
+
+// blockGroup is shared by the clauses of one if or try statement.
+type blockGroup struct {
+	kind ImportKind
+}
+
+// newBlock interprets a clause header. previous is the clause which
+// immediately precedes it at the same indentation, if any.
+func newBlock(header []token, previous *block) *block {
+	keyword := header[0].value
+	if keyword == "async" && len(header) > 1 {
+		keyword = header[1].value
+	}
+	b := &block{column: header[0].column, keyword: keyword}
+	sibling := func() *blockGroup {
+		if previous != nil && previous.group != nil {
+			return previous.group
+		}
+		return &blockGroup{}
+	}
+	switch keyword {
+	case "def":
+		b.kind = ImportFunctionLocal
+	case "if", "elif":
+		b.group, b.inGroup = &blockGroup{}, true
+		if keyword == "elif" {
+			b.group = sibling()
+		}
+		test := header[1 : len(header)-1]
+		if len(test) == 1 && test[0].is(tokenName, "TYPE_CHECKING") ||
+			len(test) == 3 && test[1].is(tokenOp, ".") && test[2].is(tokenName, "TYPE_CHECKING") {
+			b.kind = ImportTypeChecking
+		}
+		for _, tok := range test {
+			if tok.is(tokenName, "version_info") {
+				b.group.kind |= ImportVersionConditional
+			}
+		}
+	case "else":
+		if previous != nil && previous.group != nil {
+			b.group = previous.group
+			// the else clause of a try statement only runs if nothing failed
+			b.inGroup = previous.keyword == "if" || previous.keyword == "elif"
+		}
+	case "try":
+		b.group, b.inGroup = &blockGroup{}, true
+	case "except":
+		b.group, b.inGroup = sibling(), true
+		if catchesImportError(header) {
+			b.group.kind |= ImportFallback
+		}
+	case "finally":
+		b.group = sibling()
+	}
+	return b
+}
+
+// catchesImportError reports if an except clause names ImportError, alone
+// or in a tuple. Broader clauses, such as a bare except, are not treated
+// as fallbacks, as they are mostly for errors other than failed imports.
+func catchesImportError(header []token) bool {
+	for _, tok := range header[1:] {
+		if tok.is(tokenName, "as") {
+			// what follows is the name the exception is bound to
+			break
+		}
+		if tok.is(tokenName, "ImportError") || tok.is(tokenName, "ModuleNotFoundError") {
+			return true
+		}
+	}
+	return false
+}
+
+// statementKinds finds the kind of each statement, from the clauses
+// enclosing it.
+func statementKinds(statements [][]token) []ImportKind {
+	result := make([]ImportKind, len(statements))
+	for i, blocks := range enclosingBlocks(statements) {
+		for _, b := range blocks {
+			result[i] |= b.kind
+			if b.inGroup {
+				result[i] |= b.group.kind
+			}
+		}
+	}
+	return result
+}
+
+// enclosingBlocks finds the clauses enclosing each statement, outermost
+// first. Indentation is inferred from the column of the first statement
+// on each line.
+func enclosingBlocks(statements [][]token) [][]*block {
+	var stack []*block
+	enclosing := make([][]*block, len(statements))
+	for i, statement := range statements {
+		first := statement[0]
+		if i == 0 || statements[i-1][len(statements[i-1])-1].line < first.line {
+			var previous *block
+			for len(stack) > 0 && stack[len(stack)-1].column >= first.column {
+				if stack[len(stack)-1].column == first.column {
+					previous = stack[len(stack)-1]
+				}
+				stack = stack[:len(stack)-1]
+			}
+			enclosing[i] = stack
+			if statement[len(statement)-1].is(tokenOp, ":") {
+				stack = append(stack[:len(stack):len(stack)], newBlock(statement, previous))
+			}
+			continue
+		}
+		// the body of a clause on the same line, eg `try: import foo`
+		enclosing[i] = stack
+	}
+	return enclosing
+}
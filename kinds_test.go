@@ -0,0 +1,149 @@
+package pyast
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	file "github.com/nicois/file"
+)
+
+func TestImportKinds(t *testing.T) {
+	source := `import os
+from typing import TYPE_CHECKING
+if TYPE_CHECKING:
+    import checked
+    if x:
+        import nested_checked
+else:
+    import not_checked
+if typing.TYPE_CHECKING: import also_checked
+
+def f():
+    import local
+    try:
+        import local_fallback
+    except ImportError:
+        pass
+
+class C:
+    import in_class
+    async def g(self): import async_local
+
+try:
+    import ujson as json
+except ImportError:
+    import json
+else:
+    import after_success
+finally:
+    import always
+
+try:
+    import risky
+except KeyError:
+    pass
+
+try:
+    import logged
+except Exception:
+    pass
+
+try:
+    import bare
+except:
+    pass
+
+try:
+    import tupled
+except (KeyError, ModuleNotFoundError) as error:
+    pass
+
+try:
+    import bound
+except ValueError as ImportError:
+    pass
+
+if sys.version_info >= (3, 11):
+    import tomllib
+elif x:
+    import tomli
+else:
+    import toml
+import last
+`
+	expected := map[string]ImportKind{
+		"os":             ImportRuntime,
+		"typing":         ImportRuntime,
+		"checked":        ImportTypeChecking,
+		"nested_checked": ImportTypeChecking,
+		"not_checked":    ImportRuntime,
+		"also_checked":   ImportTypeChecking,
+		"local":          ImportFunctionLocal,
+		"local_fallback": ImportFunctionLocal | ImportFallback,
+		"in_class":       ImportRuntime,
+		"async_local":    ImportFunctionLocal,
+		"ujson":          ImportFallback,
+		"json":           ImportFallback,
+		"after_success":  ImportRuntime,
+		"always":         ImportRuntime,
+		"risky":          ImportRuntime,
+		"logged":         ImportRuntime,
+		"bare":           ImportRuntime,
+		"tupled":         ImportFallback,
+		"bound":          ImportRuntime,
+		"tomllib":        ImportVersionConditional,
+		"tomli":          ImportVersionConditional,
+		"toml":           ImportVersionConditional,
+		"last":           ImportRuntime,
+	}
+	found := make(map[string]ImportKind)
+	for _, statement := range parseImports(source) {
+		name := statement.names[0]
+		if statement.from {
+			name = statement.module
+		}
+		found[name] = statement.kind
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Fatalf("Found unexpected kinds: %v", found)
+	}
+
+	for _, kind := range []ImportKind{ImportRuntime, ImportTypeChecking, ImportFunctionLocal | ImportFallback} {
+		if parsed, err := ParseImportKind(kind.String()); err != nil || parsed != kind {
+			t.Fatalf("%v did not round trip: %v %v", kind, parsed, err)
+		}
+	}
+	if _, err := ParseImportKind("sometimes"); err == nil {
+		t.Fatal("expected an error for an unknown kind")
+	}
+}
+
+func TestGetDependeesIgnoringKinds(t *testing.T) {
+	root, _ := filepath.EvalSymlinks(t.TempDir())
+	writeFile(t, filepath.Join(root, "app/__init__.py"), "")
+	writeFile(t, filepath.Join(root, "app/models.py"), "")
+	writeFile(t, filepath.Join(root, "app/views.py"), "from typing import TYPE_CHECKING\nif TYPE_CHECKING:\n    from app.models import Thing\n")
+	writeFile(t, filepath.Join(root, "app/test_views.py"), "import app.views\n")
+	trees, err := BuildTreesWithOptions(context.Background(), file.CreatePaths(root), BuildTreesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	models := filepath.Join(root, "app/models.py")
+
+	dependees, err := trees.GetDependees(file.CreatePaths(models))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dependees) != 3 {
+		t.Fatalf("Found unexpected dependees: %v", dependees)
+	}
+	dependees, err = trees.GetDependeesWithOptions(file.CreatePaths(models), QueryOptions{Ignore: ImportTypeChecking})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dependees, file.CreatePaths(models)) {
+		t.Fatalf("Found unexpected dependees: %v", dependees)
+	}
+}
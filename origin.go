@@ -0,0 +1,166 @@
+package pyast
+
+import (
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Origin is where an imported module comes from.
+type Origin int
+
+const (
+	// OriginThirdParty modules are neither first-party nor in the standard library.
+	OriginThirdParty Origin = iota
+	// OriginStdlib modules are in the standard library of BuildTreesOptions.PythonVersion.
+	OriginStdlib
+	// OriginFirstParty modules are in a top-level package or module found in one of the trees.
+	OriginFirstParty
+)
+
+func (o Origin) String() string {
+	switch o {
+	case OriginStdlib:
+		return "stdlib"
+	case OriginFirstParty:
+		return "first-party"
+	}
+	return "third-party"
+}
+
+// ExternalDependencies are the top-level modules outside the trees which
+// are imported, sorted by name.
+type ExternalDependencies struct {
+	Stdlib     []string `json:"stdlib,omitempty"`
+	ThirdParty []string `json:"third_party,omitempty"`
+}
+
+func (d *ExternalDependencies) add(module string, origin Origin) {
+	switch origin {
+	case OriginStdlib:
+		d.Stdlib = appendNew(d.Stdlib, module)
+	case OriginThirdParty:
+		d.ThirdParty = appendNew(d.ThirdParty, module)
+	}
+}
+
+func (d *ExternalDependencies) sort() {
+	sort.Strings(d.Stdlib)
+	sort.Strings(d.ThirdParty)
+}
+
+// topLevel is the first component of a dotted name or class.
+func topLevel(name string) string {
+	first, _, _ := strings.Cut(name, ".")
+	return first
+}
+
+// classifier returns a function finding the origin of a top-level module.
+// First-party modules take precedence, as they shadow the standard library
+// when their root is on sys.path.
+func (t *trees) classifier() func(module string) Origin {
+	firstParty := make(map[string]bool)
+	version := ""
+	for _, tree := range *t {
+		for class := range tree.modules {
+			if top := topLevel(class); top != "__init__" {
+				firstParty[top] = true
+			}
+		}
+		if version == "" {
+			version = tree.opts.PythonVersion
+		}
+	}
+	minor, err := ParsePythonVersion(version)
+	if err != nil {
+		log.Warnf("%v; using %v", err, DefaultPythonVersion)
+		minor, _ = ParsePythonVersion(DefaultPythonVersion)
+	}
+	return func(module string) Origin {
+		switch {
+		case firstParty[module]:
+			return OriginFirstParty
+		case isStdlib(module, minor):
+			return OriginStdlib
+		}
+		return OriginThirdParty
+	}
+}
+
+// Origin finds where the module named by a dotted name or class comes from,
+// such as the nodes of RawImports.
+func (t *trees) Origin(name string) Origin {
+	return t.classifier()(topLevel(name))
+}
+
+// NodeOrigins maps every class which an import might refer to, whether or
+// not it exists, to where it comes from.
+func (t *trees) NodeOrigins() map[string]Origin {
+	classify := t.classifier()
+	result := make(map[string]Origin)
+	for _, tree := range *t {
+		for class := range tree.nodes {
+			result[class] = classify(topLevel(class))
+		}
+	}
+	return result
+}
+
+// moduleOf is the top-level module which an imported name is within.
+func (n importedName) moduleOf() string {
+	if n.Package != "" {
+		return topLevel(n.Package)
+	}
+	return topLevel(n.Name)
+}
+
+// ExternalDependencies maps the path of each scanned module which imports
+// anything outside the trees to the top-level modules it imports, other
+// than with imports of the ignored kinds.
+func (t *trees) ExternalDependencies(ignore ImportKind) map[string]ExternalDependencies {
+	classify := t.classifier()
+	result := make(map[string]ExternalDependencies)
+	for _, tree := range *t {
+		for class := range tree.modules {
+			var dependencies ExternalDependencies
+			for _, name := range tree.names[class] {
+				if ignore.ignores(name.Kind) {
+					continue
+				}
+				module := name.moduleOf()
+				if module == "" {
+					continue
+				}
+				dependencies.add(module, classify(module))
+			}
+			if len(dependencies.Stdlib) > 0 || len(dependencies.ThirdParty) > 0 {
+				dependencies.sort()
+				result[classFile(tree.root, class)] = dependencies
+			}
+		}
+	}
+	return result
+}
+
+// ExternalDependenciesByRoot is ExternalDependencies for each root, combining
+// those of the modules within it.
+func (t *trees) ExternalDependenciesByRoot(ignore ImportKind) map[string]ExternalDependencies {
+	byModule := t.ExternalDependencies(ignore)
+	result := make(map[string]ExternalDependencies)
+	for _, tree := range *t {
+		var dependencies ExternalDependencies
+		for class := range tree.modules {
+			module := byModule[classFile(tree.root, class)]
+			for _, name := range module.Stdlib {
+				dependencies.add(name, OriginStdlib)
+			}
+			for _, name := range module.ThirdParty {
+				dependencies.add(name, OriginThirdParty)
+			}
+		}
+		dependencies.sort()
+		result[tree.root] = dependencies
+	}
+	return result
+}
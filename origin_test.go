@@ -0,0 +1,70 @@
+package pyast
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	file "github.com/nicois/file"
+)
+
+func TestExternalDependencies(t *testing.T) {
+	root, _ := filepath.EvalSymlinks(t.TempDir())
+	writeFile(t, filepath.Join(root, "acme/__init__.py"), "")
+	writeFile(t, filepath.Join(root, "acme/models.py"), "import os.path\nimport json\nfrom django.db import models\nfrom . import utils\nimport tomllib\nimport distutils\n")
+	writeFile(t, filepath.Join(root, "acme/utils.py"), "import requests\nif TYPE_CHECKING:\n    import boto3\n")
+	trees, err := BuildTreesWithOptions(context.Background(), file.CreatePaths(root), BuildTreesOptions{PythonVersion: "3.10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]ExternalDependencies{
+		filepath.Join(root, "acme/models.py"): {Stdlib: []string{"distutils", "json", "os"}, ThirdParty: []string{"django", "tomllib"}},
+		filepath.Join(root, "acme/utils.py"):  {ThirdParty: []string{"boto3", "requests"}},
+	}
+	if dependencies := trees.ExternalDependencies(ImportRuntime); !reflect.DeepEqual(dependencies, expected) {
+		t.Fatalf("Found unexpected dependencies: %+v", dependencies)
+	}
+	if dependencies := trees.ExternalDependencies(ImportTypeChecking)[filepath.Join(root, "acme/utils.py")]; !reflect.DeepEqual(dependencies.ThirdParty, []string{"requests"}) {
+		t.Fatalf("Found unexpected dependencies: %+v", dependencies)
+	}
+	byRoot := map[string]ExternalDependencies{
+		root: {Stdlib: []string{"distutils", "json", "os"}, ThirdParty: []string{"boto3", "django", "requests", "tomllib"}},
+	}
+	if dependencies := trees.ExternalDependenciesByRoot(ImportRuntime); !reflect.DeepEqual(dependencies, byRoot) {
+		t.Fatalf("Found unexpected dependencies: %+v", dependencies)
+	}
+
+	if origin := trees.Origin("acme.models"); origin != OriginFirstParty {
+		t.Fatalf("Found unexpected origin: %v", origin)
+	}
+	if origin := trees.Origin("os.path"); origin != OriginStdlib {
+		t.Fatalf("Found unexpected origin: %v", origin)
+	}
+	if origin := trees.NodeOrigins()["django.db"]; origin != OriginThirdParty {
+		t.Fatalf("Found unexpected origin: %v", origin)
+	}
+
+	// the standard library depends on the version
+	trees, err = BuildTreesWithOptions(context.Background(), file.CreatePaths(root), BuildTreesOptions{PythonVersion: "3.12"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dependencies := trees.ExternalDependencies(ImportRuntime)[filepath.Join(root, "acme/models.py")]; !reflect.DeepEqual(dependencies.ThirdParty, []string{"distutils", "django"}) {
+		t.Fatalf("Found unexpected dependencies: %+v", dependencies)
+	}
+}
+
+func TestParsePythonVersion(t *testing.T) {
+	for version, expected := range map[string]int{"3.11": 11, "3.11.4": 11, "": 12} {
+		if minor, err := ParsePythonVersion(version); err != nil || minor != expected {
+			t.Fatalf("Found unexpected version for %q: %v %v", version, minor, err)
+		}
+	}
+	for _, version := range []string{"2.7", "3.99", "three"} {
+		if _, err := ParsePythonVersion(version); err == nil {
+			t.Fatalf("expected an error for %q", version)
+		}
+	}
+}
@@ -3,21 +3,16 @@ package pyast
 import (
 	"context"
 	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"hash"
-	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
 	"sync"
-	"time"
 
-	"github.com/getsentry/sentry-go"
-
-	"github.com/nicois/cache"
 	file "github.com/nicois/file"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/sync/semaphore"
@@ -28,8 +23,40 @@ type node struct {
 	isClass   bool // if true, it's a python class path. Otherwise, it's a absolute filesystem path
 }
 type tree struct {
-	root  string
-	nodes map[string]node // maps class
+	root    string
+	nodes   map[string]node    // maps class
+	imports map[string]Classes // maps importer class to the classes it imports
+	lines   map[edge]int       // line of the statement creating each import
+	modules Classes            // every module which was scanned
+
+	// names maps importer class to the names in its import statements
+	names map[string][]importedName
+	// resolved maps importer class to the modules it imports which exist
+	// in any of the trees. It is populated by trees.resolve().
+	resolved map[string][]ResolvedImport
+	// resolvedImporters is the reverse of resolved
+	resolvedImporters map[string]Classes
+	// opts are the options the tree was built with
+	opts BuildTreesOptions
+	// ignorer decides which files beneath root are not scanned
+	ignorer *ignorer
+	// canonical maps the path of each scanned module, as it was reached
+	// from root, to the same path with symlinks resolved
+	canonical map[string]string
+	// walked is the reverse of canonical
+	walked map[string]file.Paths
+	// hashes maps the class of each scanned module to a hash of its content
+	hashes map[string]string
+	// stats maps the class of each scanned module to the size and
+	// modification time its content was hashed at
+	stats map[string]fileStat
+	// symbols maps the class of each scanned module to the names it binds
+	symbols map[string]moduleSymbols
+}
+
+type edge struct {
+	importer string
+	imported string
 }
 
 /*
@@ -110,39 +137,43 @@ func (t *trees) getImportersAcrossTrees(class string) Classes {
 	return result
 }
 
-// classToPathAcrossTrees converts a class name to a file path by checking
-// which tree root actually contains the file.
-func (t *trees) classToPathAcrossTrees(class string) (string, bool) {
-	for _, tree := range *t {
-		path := ClassToPath(tree.root, class)
-		if file.FileExists(path) {
-			return path, true
-		}
-	}
-	return "", false
+// GetDependees returns the given paths, along with every scanned module which
+// imports them, directly or indirectly. Only imports which resolve to a
+// module in one of the trees are followed, except that files which no longer
+// exist are matched against every class their importers might have meant.
+func (t *trees) GetDependees(paths file.Paths) (file.Paths, error) {
+	return t.GetDependeesWithOptions(paths, QueryOptions{})
 }
 
-func (t *trees) GetDependees(paths file.Paths) (file.Paths, error) {
-	result := file.CreatePaths()
+// GetDependeesWithOptions is like GetDependees, but only follows imports
+// permitted by opts.
+func (t *trees) GetDependeesWithOptions(paths file.Paths, opts QueryOptions) (file.Paths, error) {
 	seen := CreateClasses()
 
 	// Seed: convert input paths to class names using the correct tree
 	pending := CreateClasses()
 	for path := range paths {
-		if class, ok := t.pathToClassAcrossTrees(path); ok {
-			pending.Add(class)
-		}
+		pending.Union(t.classesOf(path))
 	}
 
 	// Iteratively resolve importers across all trees until stable
-	for len(pending) > 0 {
+	for depth := 0; len(pending) > 0; depth++ {
+		if opts.MaxDepth > 0 && depth >= opts.MaxDepth {
+			seen.Union(pending)
+			break
+		}
 		nextPending := CreateClasses()
 		for class := range pending {
 			if _, already := seen[class]; already {
 				continue
 			}
 			seen.Add(class)
-			importers := t.getImportersAcrossTrees(class)
+			var importers Classes
+			if t.isModule(class) {
+				importers = t.getResolvedImportersAcrossTrees(class, opts.Ignore)
+			} else {
+				importers = t.getRawImportersAcrossTrees(class, opts.Ignore)
+			}
 			for importer := range importers {
 				if _, already := seen[importer]; !already {
 					nextPending.Add(importer)
@@ -152,27 +183,24 @@ func (t *trees) GetDependees(paths file.Paths) (file.Paths, error) {
 		pending = nextPending
 	}
 
-	// Convert seen classes back to file paths
-	for class := range seen {
-		if path, ok := t.classToPathAcrossTrees(class); ok {
-			result.Add(path)
-		}
+	if opts.Canonical {
+		return t.canonicalPaths(t.resolvedPaths(seen)), nil
 	}
-	return result, nil
+	return t.resolvedPaths(seen), nil
 }
 
 func (t *tree) GetDependees(paths file.Paths) (file.Paths, error) {
+	for path := range paths {
+		if p, err := filepath.Abs(path); err != nil || p != path {
+			return nil, fmt.Errorf("%v should be absolute already", path)
+		}
+	}
 	dependees := make(chan string, 10)
 	result := file.CreatePaths()
 	go func() {
 		var wg sync.WaitGroup
 		seen := seen{mutex: new(sync.Mutex), nodes: make(Classes)}
 		for path := range paths {
-			if p, err := filepath.Abs(path); err != nil || p != path {
-				sentry.CaptureException(err)
-				log.Fatalf("%v should be absolute already", path)
-			}
-
 			if !strings.HasPrefix(path, t.root) {
 				log.Debugf("%v is not contained within %v. Ignoring it.", path, t.root)
 				continue
@@ -196,10 +224,26 @@ func (t *tree) GetDependees(paths file.Paths) (file.Paths, error) {
 	return result, nil
 }
 
+// cacheFormat is mixed into every cache key. Change it whenever the cached
+// representation, or the way it is calculated, changes.
+const cacheFormat = "names-6"
+
 type depPair struct {
 	importerClass string
 	imported      string // ie: what is imported by the importer
 	isClass       bool   // is the imported object a class? if not, assume it's an absolute path
+	line          int    // where the importer imports it
+}
+
+// scanned is the result of scanning a single module.
+type scanned struct {
+	class     string
+	names     []importedName
+	symbols   moduleSymbols
+	path      string // as it was reached from the root
+	canonical string // with symlinks resolved
+	hash      string // of the content
+	stat      fileStat
 }
 
 // BuildTreesOptions controls tree-building behavior.
@@ -207,20 +251,93 @@ type BuildTreesOptions struct {
 	// NamespacePackages disables the __init__.py requirement when walking
 	// directories. Required for implicit namespace packages.
 	NamespacePackages bool
+
+	// FailFast stops building as soon as any file cannot be processed, and
+	// returns no trees. Otherwise, problem files are left out of the trees
+	// and reported together in a *BuildError alongside the partial result.
+	FailFast bool
+
+	// StringReferences, if set, also treats dotted paths in some string
+	// literals as imports of kind ImportStringRef. See DefaultStringReferences.
+	StringReferences *StringReferenceOptions
+
+	// Pytest, if set, adds the dependencies which pytest creates, as
+	// imports of kind ImportImplicit.
+	Pytest *PytestOptions
+
+	// Exclude are patterns, in .gitignore syntax, of files and directories
+	// which are not scanned. Patterns containing a / (other than at the
+	// end) must be absolute, and others match the base name at any depth.
+	// Later patterns starting with ! re-include what earlier ones excluded.
+	Exclude []string
+
+	// NoGitignore scans files even if they are ignored by git. Otherwise,
+	// the .gitignore files within and above each root, and .git/info/exclude,
+	// are honoured.
+	NoGitignore bool
+
+	// Versioner decides when the imports cached for a file are out of
+	// date. Defaults to ContentVersioner.
+	Versioner Versioner `json:"-"`
+
+	// Cache stores the imports found in each file. Defaults to an sqlite
+	// database in ~/.cache, or NoCache if that cannot be opened.
+	Cache ImportCache `json:"-"`
+
+	// Symbols also records the names which each top-level definition
+	// refers to, so that GetDependeesOfSymbols can follow changes to
+	// individual definitions rather than whole modules.
+	Symbols bool
+
+	// PythonVersion, such as "3.11", decides which imports are of the
+	// standard library. Defaults to DefaultPythonVersion.
+	PythonVersion string
+
+	// ImplicitImports maps globs of absolute paths to files which the
+	// matching modules depend on without importing them, as imports of
+	// kind ImportImplicit.
+	ImplicitImports map[string][]string
+}
+
+// parseOptions are the BuildTreesOptions which affect what is extracted
+// from each file, so they are part of its cache key.
+type parseOptions struct {
+	StringReferences *StringReferenceOptions `json:"string_references,omitempty"`
+	PytestPlugins    bool                    `json:"pytest_plugins,omitempty"`
+	Symbols          bool                    `json:"symbols,omitempty"`
+}
+
+func (o BuildTreesOptions) parseOptions() parseOptions {
+	return parseOptions{StringReferences: o.StringReferences, PytestPlugins: o.Pytest != nil, Symbols: o.Symbols}
 }
 
 // BuildTrees builds import dependency trees for the given Python roots.
+// Files which cannot be processed are logged and left out of the trees.
 func BuildTrees(ctx context.Context, pythonRoots file.Paths) *trees {
-	return BuildTreesWithOptions(ctx, pythonRoots, BuildTreesOptions{})
+	result, err := BuildTreesWithOptions(ctx, pythonRoots, BuildTreesOptions{})
+	if err != nil {
+		log.Warn(err)
+	}
+	if result == nil {
+		result = &trees{}
+	}
+	return result
 }
 
 // BuildTreesWithOptions builds import dependency trees with configurable behavior.
-func BuildTreesWithOptions(ctx context.Context, pythonRoots file.Paths, opts BuildTreesOptions) *trees {
+// If some files could not be processed, the error is a *BuildError listing them.
+func BuildTreesWithOptions(ctx context.Context, pythonRoots file.Paths, opts BuildTreesOptions) (*trees, error) {
+	buildCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	failures := createBuildErrors(nil)
+	if opts.FailFast {
+		failures.cancel = cancel
+	}
 	var wg sync.WaitGroup
 	c := make(chan tree)
 	for pythonRoot := range pythonRoots {
 		wg.Add(1)
-		go buildTreeWithOptions(ctx, &wg, c, pythonRoot, opts)
+		go buildTreeWithOptions(buildCtx, &wg, c, pythonRoot, opts, failures)
 	}
 	go func() {
 		wg.Wait()
@@ -230,6 +347,7 @@ func BuildTreesWithOptions(ctx context.Context, pythonRoots file.Paths, opts Bui
 	for t := range c {
 		result = append(result, t)
 	}
+	result.resolve()
 	if destinationFilename := os.Getenv("PYAST_DUMP_LOCATION"); destinationFilename != "" {
 		destination, err := os.Create(destinationFilename)
 		if err == nil {
@@ -246,38 +364,119 @@ func BuildTreesWithOptions(ctx context.Context, pythonRoots file.Paths, opts Bui
 		}
 
 	}
-	return &result
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := failures.err(); err != nil {
+		if opts.FailFast {
+			return nil, err
+		}
+		return &result, err
+	}
+	return &result, nil
 }
 
+// BuildTree builds the tree for a single python root, sending it to c.
+// Files which cannot be processed are left out of the tree.
 func BuildTree(ctx context.Context, pwg *sync.WaitGroup, c chan tree, pythonRoot string) {
-	buildTreeWithOptions(ctx, pwg, c, pythonRoot, BuildTreesOptions{})
+	buildTreeWithOptions(ctx, pwg, c, pythonRoot, BuildTreesOptions{}, createBuildErrors(nil))
 }
 
-func buildTreeWithOptions(ctx context.Context, pwg *sync.WaitGroup, c chan tree, pythonRoot string, opts BuildTreesOptions) {
+func buildTreeWithOptions(ctx context.Context, pwg *sync.WaitGroup, c chan tree, pythonRoot string, opts BuildTreesOptions, failures *buildErrors) {
 	defer pwg.Done()
 	pythonRoot, err := filepath.Abs(pythonRoot)
 	if err != nil {
-		sentry.CaptureException(err)
-		log.Fatal(err)
+		failures.add(pythonRoot, err)
+		return
 	}
 	var wg sync.WaitGroup
-	depPairs := make(chan depPair)
+	modules := make(chan scanned)
+	t := createTree(pythonRoot)
+	t.opts = opts
+	t.ignorer = newIgnorer(pythonRoot, opts)
 	wg.Add(1)
-	go buildDependencies(ctx, &wg, pythonRoot, depPairs, opts.NamespacePackages)
+	go buildDependencies(ctx, &wg, pythonRoot, modules, opts, t.ignorer, failures)
 	go func() {
 		wg.Wait()
-		close(depPairs)
+		close(modules)
 	}()
-	nodes := make(map[string]node)
-	for pair := range depPairs {
-		n, ok := nodes[pair.imported]
-		if !ok {
-			n = node{importers: CreateClasses(), isClass: pair.isClass}
-			nodes[pair.imported] = n
+	for module := range modules {
+		t.addModule(module.class, module.names)
+		t.addCanonical(module.path, module.canonical)
+		t.hashes[module.class] = module.hash
+		t.stats[module.class] = module.stat
+		t.symbols[module.class] = module.symbols
+	}
+	c <- *t
+}
+
+func createTree(root string) *tree {
+	return &tree{
+		root:              root,
+		nodes:             make(map[string]node),
+		imports:           make(map[string]Classes),
+		lines:             make(map[edge]int),
+		modules:           CreateClasses(),
+		names:             make(map[string][]importedName),
+		resolved:          make(map[string][]ResolvedImport),
+		resolvedImporters: make(map[string]Classes),
+		canonical:         make(map[string]string),
+		walked:            make(map[string]file.Paths),
+		hashes:            make(map[string]string),
+		stats:             make(map[string]fileStat),
+		symbols:           make(map[string]moduleSymbols),
+	}
+}
+
+// addModule records a scanned module, and every candidate class it might import.
+func (t *tree) addModule(class string, names []importedName) {
+	t.modules.Add(class)
+	t.names[class] = names
+	for _, name := range names {
+		for _, candidate := range name.candidates() {
+			t.addPair(depPair{importerClass: class, imported: candidate, isClass: true, line: name.Line})
 		}
-		n.importers.Add(pair.importerClass)
 	}
-	c <- tree{root: pythonRoot, nodes: nodes}
+}
+
+// addPair records a single import.
+func (t *tree) addPair(pair depPair) {
+	n, ok := t.nodes[pair.imported]
+	if !ok {
+		n = node{importers: CreateClasses(), isClass: pair.isClass}
+		t.nodes[pair.imported] = n
+	}
+	n.importers.Add(pair.importerClass)
+	imported, ok := t.imports[pair.importerClass]
+	if !ok {
+		imported = CreateClasses()
+		t.imports[pair.importerClass] = imported
+	}
+	imported.Add(pair.imported)
+	if _, ok := t.lines[edge{importer: pair.importerClass, imported: pair.imported}]; !ok {
+		t.lines[edge{importer: pair.importerClass, imported: pair.imported}] = pair.line
+	}
+}
+
+// removeModule forgets a module and everything it imports, so
+// that it can be rescanned.
+func (t *tree) removeModule(importerClass string) {
+	delete(t.modules, importerClass)
+	delete(t.names, importerClass)
+	delete(t.hashes, importerClass)
+	delete(t.stats, importerClass)
+	delete(t.symbols, importerClass)
+	t.removeCanonical(classFile(t.root, importerClass))
+	for imported := range t.imports[importerClass] {
+		if n, ok := t.nodes[imported]; ok {
+			delete(n.importers, importerClass)
+			if len(n.importers) == 0 {
+				delete(t.nodes, imported)
+			}
+		}
+		delete(t.lines, edge{importer: importerClass, imported: imported})
+	}
+	delete(t.imports, importerClass)
 }
 
 /*
@@ -316,161 +515,286 @@ func (c *cache) Put(path string, classes Classes) {
 }
 */
 
-func buildDependencies(ctx context.Context, wg *sync.WaitGroup, pythonRoot string, depPairs chan depPair, namespacePackages bool) {
+func buildDependencies(ctx context.Context, wg *sync.WaitGroup, pythonRoot string, modules chan scanned, opts BuildTreesOptions, ignorer *ignorer, failures *buildErrors) {
 	// this controls the maximum number of files being read
 	// concurrently, to avoid "too many open files" errors
 	defer wg.Done()
 	sem := semaphore.NewWeighted(10)
-	pythonRoot, err := filepath.EvalSymlinks(pythonRoot)
-	if err != nil {
-		log.Infof("While evaluating symlink %v: %v. Temporarily ignoring this module.", pythonRoot, err)
-		return
-	}
-	// create cache object w/ lock channel
-	cacher, err := cache.Create[time.Time](ctx, "pyast")
-	if err != nil {
-		log.Error(err)
+	importCache := opts.Cache
+	if importCache == nil {
+		if c, err := NewSQLiteCache(ctx, "pyast"); err == nil {
+			importCache = c
+		} else {
+			log.Warnf("Could not open the cache, so every file will be parsed: %v", err)
+			importCache = NoCache
+		}
 	}
-	// FIXME: handle symlinks, either as files or directories
-	filepath.WalkDir(pythonRoot, func(path string, d fs.DirEntry, err error) error {
+	walkModules(ctx, pythonRoot, opts, ignorer, failures, func(path string, canonical string) {
+		wg.Add(1)
+		go scan(ctx, wg, importCache, modules, sem, pythonRoot, path, canonical, opts.parseOptions(), opts.versioner(), failures)
+	})
+}
+
+// walkModules calls fn with each python file beneath pythonRoot which should be scanned.
+func walkModules(ctx context.Context, pythonRoot string, opts BuildTreesOptions, ignorer *ignorer, failures *buildErrors, fn func(path string, canonical string)) {
+	// symlinks are followed, so that modules have the class they are
+	// imported as, which depends on the path they are reached through
+	walkFollowingSymlinks(pythonRoot, func(path string, canonical string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return fs.SkipAll
+		}
+		if err != nil {
+			failures.add(path, err)
+			return nil
+		}
+		if path != pythonRoot && ignorer.ignores(path, d.IsDir()) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
 		if d.IsDir() {
-			if path != pythonRoot && !namespacePackages && !file.FileExists(filepath.Join(path, "__init__.py")) {
+			if path != pythonRoot && !opts.NamespacePackages && !file.FileExists(filepath.Join(path, "__init__.py")) {
 				// log.Debugf("%v does not contain __init__.py so skipping.", path)
 				return fs.SkipDir
 			}
 			return nil
 		}
 		if strings.HasSuffix(path, ".py") {
-			wg.Add(1)
-			go scan(ctx, wg, cacher, depPairs, sem, pythonRoot, path)
+			fn(path, canonical)
 		}
 		return nil
 	})
 }
 
+// stripComments removes comments and triple-quoted strings (usually docstrings)
+// from python source, leaving everything else untouched.
 func stripComments(source string) string {
-	expressions := []*regexp.Regexp{regexp.MustCompile(`(?m)'''.+?'''`), regexp.MustCompile(`(?m)""".+?"""`)} //, regexp.MustCompile(`.+'''`)}
-	for _, re := range expressions {
-		source = re.ReplaceAllLiteralString(source, "")
-	}
-	re := regexp.MustCompile(`(?m)(\".*?\"|\'.*?\')|(#[^\r\n]*$)`)
-	result := re.ReplaceAllStringFunc(source, func(s string) string {
-		if strings.HasPrefix(s, "'") || strings.HasPrefix(s, "\"") {
-			return s
+	var result strings.Builder
+	previous := 0
+	for _, tok := range tokenize(source) {
+		if tok.kind == tokenComment || (tok.kind == tokenString && isTripleQuoted(tok.value)) {
+			result.WriteString(source[previous:tok.start])
+			previous = tok.end
 		}
-		return ""
-	})
-	return strings.TrimSpace(result)
+	}
+	result.WriteString(source[previous:])
+	return strings.TrimSpace(result.String())
 }
 
-func scan(ctx context.Context, wg *sync.WaitGroup, cacher cache.Cacher[time.Time], depPairs chan depPair, sem *semaphore.Weighted, root string, path string) {
+func scan(ctx context.Context, wg *sync.WaitGroup, importCache ImportCache, modules chan scanned, sem *semaphore.Weighted, root string, path string, canonical string, parsing parseOptions, versioner Versioner, failures *buildErrors) {
 	/*
-	   Responsible for sending depPairs on the designated channel for the specified python file at `path`.
+	   Responsible for sending what the python file at `path` imports on the designated channel.
 	*/
 	defer wg.Done()
 	if !strings.HasPrefix(path, root) {
-		log.Fatalf("%v does not start with %v, so cannot calculate the module path.", path, root)
+		failures.add(path, fmt.Errorf("does not start with %v, so cannot calculate the module path", root))
+		return
 	}
 	if err := sem.Acquire(ctx, 1); err != nil {
 		return
 	}
+	// before reading, so that a later change is never missed by Refresh
+	stat, _ := statFile(path)
 	content, err := file.ReadBytes(path)
 	sem.Release(1)
 	if err != nil {
-		sentry.CaptureException(err)
-		log.Fatalf("While reading %v: %v", path, err)
+		failures.add(path, err)
+		return
 	}
-	hasher := sha256.New()
-	hasher.Write([]byte(path))
 	class, err := PathToClass(path[len(root)+1:])
 	if err != nil {
-		log.Warnln(err)
+		failures.add(path, err)
+		return
 	}
-	versioner := cache.CreateReactiveListener(mtimeVersioner(path), time.Second/10)
-	for dep := range createDependencies(ctx, cacher, hasher, versioner, class, content) {
-		depPairs <- depPair{importerClass: class, imported: dep, isClass: true}
+	version, err := versioner(path, content)
+	if err != nil {
+		failures.add(path, err)
+		return
+	}
+	// the path is left out, so that caches can be shared between checkouts;
+	// the class is needed to resolve relative imports
+	hasher := sha256.New()
+	hasher.Write([]byte(cacheFormat))
+	hasher.Write([]byte(class))
+	if parsing != (parseOptions{}) {
+		// what is found depends on the options
+		serialisedOptions, _ := json.Marshal(parsing)
+		hasher.Write(serialisedOptions)
+	}
+	hasher.Write([]byte(version))
+	key := hex.EncodeToString(hasher.Sum(nil))
+	names, symbols, err := createDependencies(ctx, importCache, key, class, content, parsing)
+	if err != nil {
+		if ctx.Err() == nil {
+			failures.add(path, err)
+		}
+		var partial *partialError
+		if !errors.As(err, &partial) {
+			return
+		}
 	}
+	modules <- scanned{class: class, names: names, symbols: symbols, path: path, canonical: canonical, hash: contentHash(content), stat: stat}
 }
 
-func mtimeVersioner(path string) func() (time.Time, error) {
-	return func() (time.Time, error) {
-		fileinfo, err := os.Stat(path)
-		if err != nil {
-			return time.Time{}, err
+// importedName is a single name in an import statement, with
+// relative imports already resolved.
+type importedName struct {
+	Package string     `json:"package,omitempty"` // for "from package import name"
+	Name    string     `json:"name"`              // a dotted module path for "import name"
+	Line    int        `json:"line"`
+	Kind    ImportKind `json:"kind,omitempty"`
+	Alias   string     `json:"alias,omitempty"` // for "import name as alias"
+}
+
+func (n importedName) dotted() string {
+	if n.Package == "" {
+		return n.Name
+	}
+	return n.Package + "." + n.Name
+}
+
+// binding is the name which the import binds in the importing module, or
+// "" if it binds none, as for dynamic imports and string references.
+func (n importedName) binding() string {
+	if n.Kind&(ImportDynamic|ImportStringRef|ImportImplicit) != 0 {
+		return ""
+	}
+	if n.Alias != "" {
+		return n.Alias
+	}
+	if n.Package != "" {
+		return n.Name
+	}
+	// `import a.b` binds a
+	first, _, _ := strings.Cut(n.Name, ".")
+	return first
+}
+
+// candidates are the classes this name might refer to.
+// "from foo import bar" might mean foo is a module, or foo.bar is,
+// so add the parent as well.
+func (n importedName) candidates() []string {
+	if n.Name == "*" && n.Package != "" {
+		// anything the module exports, which is not known until it is resolved
+		return []string{n.Package, n.Package + ".__init__"}
+	}
+	dep := n.dotted()
+	result := []string{dep, dep + ".__init__"}
+	if lastDotIndex := strings.LastIndex(dep, "."); lastDotIndex > 0 {
+		result = append(result, dep[:lastDotIndex])
+		if n.Kind&ImportStringRef != 0 {
+			// a string reference may be to anything within a module
+			for i := strings.LastIndex(dep[:lastDotIndex], "."); i > 0; i = strings.LastIndex(dep[:i], ".") {
+				result = append(result, dep[:i])
+			}
 		}
-		return fileinfo.ModTime(), nil
 	}
+	return result
 }
 
 // extractImportsFromModule calculates all the "class paths" which are imported by this python code.
 // For example, "import foo" will return {"foo", "foo.__init__"}. It does not matter if some of these
-// don't actually exist.
+// don't actually exist. Relative imports which cannot be resolved are skipped.
 func extractImportsFromModule(class string, content string) Classes {
-	reImport := regexp.MustCompile(`(?m)^\s*(?:from[ ]+(\S+)[ ]+)?import[ ]+([^\(]+?|\([^\)]+?\))(?:[ ]+as[ ]+\S+)?[ ]*$`)
 	classes := CreateClasses()
-	for _, match := range reImport.FindAllStringSubmatch(stripComments(string(content)), -1) {
-		packageName := match[1]
-		var names []string
-		if strings.Contains(match[2], ",") {
-			names = strings.Split(strings.Trim(match[2], "()"), ",")
-		} else {
-			names = []string{match[2]}
-		}
+	names, _ := extractImportedNames(class, content, parseOptions{})
+	for _, name := range names {
+		classes.Add(name.candidates()...)
+	}
+	return classes
+}
+
+// extractImportedNames finds the names in each import statement, and anything
+// else which the options treat as an import. If some relative imports climb
+// above the top-level package, the others are still returned along with an error.
+func extractImportedNames(class string, content string, parsing parseOptions) ([]importedName, error) {
+	names, _, err := extractModule(class, content, parsing)
+	return names, err
+}
 
-		if strings.HasPrefix(packageName, ".") {
-			// parentClass := class[:strings.LastIndex(class, ".")]
+// extractModule is extractImportedNames, also finding the module's symbols.
+func extractModule(class string, content string, parsing parseOptions) ([]importedName, moduleSymbols, error) {
+	var names []importedName
+	var unresolved []string
+	statements, symbols := parseModule(content, parsing)
+	for _, statement := range statements {
+		packageName := statement.module
+		if statement.level > 0 {
 			parentClass := class
-			for ; strings.HasPrefix(packageName, "."); packageName = packageName[1:] {
-				if strings.LastIndex(class, ".") == -1 {
-					log.Fatalf("Looking for . in %v (class) with %v (packageName) and %q (match)", class, packageName, match)
+			levels := statement.level
+			if statement.relativeToName && !strings.HasSuffix(class, ".__init__") {
+				// __name__ is the module itself, rather than its package
+				levels--
+			}
+			for i := 0; i < levels && parentClass != ""; i++ {
+				if lastDotIndex := strings.LastIndex(parentClass, "."); lastDotIndex >= 0 {
+					parentClass = parentClass[:lastDotIndex]
+				} else {
+					parentClass = ""
 				}
-				parentClass = parentClass[:strings.LastIndex(parentClass, ".")]
 			}
-			if len(packageName) > 1 {
+			if parentClass == "" {
+				unresolved = append(unresolved, fmt.Sprintf("line %v: %v", statement.line, strings.Repeat(".", statement.level)+statement.module))
+				continue
+			}
+			if packageName != "" {
 				packageName = parentClass + "." + packageName
 			} else {
 				packageName = parentClass
 			}
 		}
-		// add the parent as a dep, as e.g. "from foo import bar" might mean
-		// foo is a module, or foo.bar
-		for _, name := range names {
-			var dep string
-			if packageName == "" {
-				dep = strings.TrimSpace(name)
-			} else {
-				dep = fmt.Sprintf("%v.%v", packageName, strings.TrimSpace(name))
-			}
-			classes.Add(dep)
-			classes.Add(dep + ".__init__")
-			if lastDotIndex := strings.LastIndex(dep, "."); lastDotIndex > 0 {
-				classes.Add(dep[:lastDotIndex])
+		for j, name := range statement.names {
+			imported := importedName{Package: packageName, Name: name, Line: statement.line, Kind: statement.kind}
+			if j < len(statement.alias) {
+				imported.Alias = statement.alias[j]
 			}
+			names = append(names, imported)
 		}
 	}
-	return classes
+	if len(unresolved) > 0 {
+		return names, symbols, fmt.Errorf("relative imports in %v go beyond the top-level package: %v", class, strings.Join(unresolved, ", "))
+	}
+	return names, symbols, nil
 }
 
-func createDependencies(ctx context.Context, cacher cache.Cacher[time.Time], hasher hash.Hash, versioner cache.Version[time.Time], class string, content []byte) Classes {
-	serialisedClasses, err := cacher.Cache(ctx, hasher, func(ctx context.Context, stdout io.Writer, stderr io.Writer) ([]byte, error) {
-		return json.Marshal(extractImportsFromModule(class, string(content)).Lister())
-	}, versioner)
+func createDependencies(ctx context.Context, importCache ImportCache, key string, class string, content []byte, parsing parseOptions) ([]importedName, moduleSymbols, error) {
+	extract := func() ([]byte, error) {
+		source, err := decodeSource(content)
+		if err != nil {
+			log.Warnf("While decoding %v: %v", class, err)
+		}
+		var result cachedImports
+		result.Names, result.Symbols, err = extractModule(class, source, parsing)
+		if err != nil {
+			// still cache the imports which could be resolved
+			result.Problem = err.Error()
+		}
+		return json.Marshal(result)
+	}
+	serialised, err := importCache.Cache(ctx, key, extract)
 	if err != nil {
-		sentry.CaptureException(err)
-		log.Fatal(err)
+		return nil, moduleSymbols{}, err
 	}
-	if len(serialisedClasses) == 0 {
+	if len(serialised) == 0 {
 		log.Debug("No classes found.")
-		return CreateClasses()
+		return nil, moduleSymbols{}, nil
 	}
-	var listOfClasses []string
-	if err = json.Unmarshal(serialisedClasses, &listOfClasses); err != nil {
-		sentry.CaptureException(err)
-		log.Fatalf("While processing %v: %v: %v", class, err, string(serialisedClasses))
+	var result cachedImports
+	if err = json.Unmarshal(serialised, &result); err != nil {
+		return nil, moduleSymbols{}, fmt.Errorf("while processing cached imports of %v: %w: %v", class, err, string(serialised))
 	}
-	result := CreateClasses(listOfClasses...)
-	// log.Warnf("%v -> %q", class, listOfClasses)
-	return result
+	if result.Problem != "" {
+		return result.Names, result.Symbols, &partialError{problem: result.Problem}
+	}
+	return result.Names, result.Symbols, nil
+}
+
+// cachedImports is what is cached for each file.
+type cachedImports struct {
+	Names   []importedName `json:"names"`
+	Symbols moduleSymbols  `json:"symbols"`
+	Problem string         `json:"problem,omitempty"` // set if some imports could not be resolved
 }
 
 // CalculatePythonRoots handles the situation where a repository contains multiple python projects.
@@ -478,7 +802,7 @@ func createDependencies(ctx context.Context, cacher cache.Cacher[time.Time], has
 // it is a separate project.
 // This function creates a set of these "root" directories, which contain at least one of the
 // input paths and does not contain __init__.py
-func CalculatePythonRoots(paths file.Paths) file.Paths {
+func CalculatePythonRoots(paths file.Paths) (file.Paths, error) {
 	result := file.CreatePaths()
 	for path := range paths {
 		if !strings.HasSuffix(path, ".py") {
@@ -494,15 +818,15 @@ func CalculatePythonRoots(paths file.Paths) file.Paths {
 			if !file.FileExists(filepath.Join(dir, "__init__.py")) {
 				break
 			}
-			dir = filepath.Dir(dir)
-			if !file.DirExists(dir) {
-				sentry.CaptureException(err)
-				log.Fatalf("%v does not exist, while trying to find top-level of %v", dir, path)
+			parent := filepath.Dir(dir)
+			if parent == dir || !file.DirExists(parent) {
+				return nil, fmt.Errorf("%v does not exist, while trying to find top-level of %v", parent, path)
 			}
+			dir = parent
 		}
 		result.Add(dir)
 	}
-	return result
+	return result, nil
 }
 
 func PathToClass(path string) (string, error) {
@@ -519,7 +843,7 @@ func PathToClass(path string) (string, error) {
 
 func ClassToPath(root string, class string) string {
 	base := strings.ReplaceAll(class, ".", "/")
-	if file.DirExists(base) {
+	if file.DirExists(filepath.Join(root, base)) {
 		// PathToClass will retain the __init__ suffix,
 		// but other sources may omit it. If so, put it back.
 		return filepath.Join(root, base+"/__init__.py")
@@ -3,6 +3,7 @@ package pyast
 import (
 	"context"
 	"path/filepath"
+	"reflect"
 	"testing"
 
 	file "github.com/nicois/file"
@@ -100,6 +101,44 @@ from myapp.deploy.services import foo, bar
 	}
 }
 
+func TestExtractImportsFromModuleTokenized(t *testing.T) {
+	// imports inside strings are not imports
+	if actual := extractImportsFromModule("", `
+"""
+import notthis
+"""
+x = "# import notthis"; import foo
+    `); !CreateClasses("foo", "foo.__init__").SameAs(actual) {
+		t.Fatalf("Found unexpected result:\n%q", actual)
+	}
+
+	// backslash continuations and aliases
+	if actual := extractImportsFromModule("", `
+from foo \
+    import bar as b, baz
+import qux as q, quux
+    `); !CreateClasses("foo.bar", "foo.baz", "foo", "foo.bar.__init__", "foo.baz.__init__", "qux", "qux.__init__", "quux", "quux.__init__").SameAs(actual) {
+		t.Fatalf("Found unexpected result:\n%q", actual)
+	}
+
+	// imports in the body of a compound statement on the same line
+	if actual := extractImportsFromModule("myapp.utils.__init__", `
+try: from . import x
+except ImportError: x = None
+    `); !CreateClasses("myapp.utils.x", "myapp.utils.x.__init__", "myapp.utils").SameAs(actual) {
+		t.Fatalf("Found unexpected result:\n%q", actual)
+	}
+
+	// single-character relative module names
+	if actual := extractImportsFromModule("myapp.utils.__init__", `
+from .x import (
+    y,  # a comment
+)
+    `); !CreateClasses("myapp.utils.x.y", "myapp.utils.x.y.__init__", "myapp.utils.x").SameAs(actual) {
+		t.Fatalf("Found unexpected result:\n%q", actual)
+	}
+}
+
 func TestBuildTreesNamespacePackages(t *testing.T) {
 	root, err := filepath.Abs("testdata/namespace/src")
 	if err != nil {
@@ -109,7 +148,10 @@ func TestBuildTreesNamespacePackages(t *testing.T) {
 	ctx := context.Background()
 
 	opts := BuildTreesOptions{NamespacePackages: true}
-	trees := BuildTreesWithOptions(ctx, roots, opts)
+	trees, err := BuildTreesWithOptions(ctx, roots, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	consumerPath := filepath.Join(root, "avn/kafka/consumer.py")
 	deps, err := trees.GetDependees(file.CreatePaths(consumerPath))
@@ -129,7 +171,10 @@ func TestCrossRootDependees(t *testing.T) {
 
 	roots := file.CreatePaths(repoRoot, kafkaSrc)
 	opts := BuildTreesOptions{NamespacePackages: true}
-	trees := BuildTreesWithOptions(context.Background(), roots, opts)
+	trees, err := BuildTreesWithOptions(context.Background(), roots, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	consumerPath := filepath.Join(kafkaSrc, "avn/kafka/consumer.py")
 	deps, err := trees.GetDependees(file.CreatePaths(consumerPath))
@@ -149,7 +194,10 @@ func TestOverlappingRootPrefixes(t *testing.T) {
 
 	roots := file.CreatePaths(repoRoot, kafkaSrc)
 	opts := BuildTreesOptions{NamespacePackages: true}
-	trees := BuildTreesWithOptions(context.Background(), roots, opts)
+	trees, err := BuildTreesWithOptions(context.Background(), roots, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	consumerPath := filepath.Join(kafkaSrc, "avn/kafka/consumer.py")
 	class, ok := trees.pathToClassAcrossTrees(consumerPath)
@@ -168,7 +216,10 @@ func TestCrossProjectAvnImports(t *testing.T) {
 
 	roots := file.CreatePaths(repoRoot, kafkaSrc, metricsSrc)
 	opts := BuildTreesOptions{NamespacePackages: true}
-	trees := BuildTreesWithOptions(context.Background(), roots, opts)
+	trees, err := BuildTreesWithOptions(context.Background(), roots, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	consumerPath := filepath.Join(kafkaSrc, "avn/kafka/consumer.py")
 	deps, err := trees.GetDependees(file.CreatePaths(consumerPath))
@@ -189,7 +240,10 @@ func TestTransitiveCrossRootDeps(t *testing.T) {
 
 	roots := file.CreatePaths(repoRoot, kafkaSrc, metricsSrc)
 	opts := BuildTreesOptions{NamespacePackages: true}
-	trees := BuildTreesWithOptions(context.Background(), roots, opts)
+	trees, err := BuildTreesWithOptions(context.Background(), roots, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	consumerPath := filepath.Join(kafkaSrc, "avn/kafka/consumer.py")
 	deps, err := trees.GetDependees(file.CreatePaths(consumerPath))
@@ -229,3 +283,52 @@ func TestBuildTreesBackwardCompat(t *testing.T) {
 		t.Errorf("without namespace packages, producer.py should NOT be detected as depending on consumer.py, but got: %v", deps)
 	}
 }
+
+func TestGetDependencies(t *testing.T) {
+	root, _ := filepath.Abs("testdata/forward/src")
+	trees := BuildTrees(context.Background(), file.CreatePaths(root))
+
+	a := filepath.Join(root, "app/a.py")
+	b := filepath.Join(root, "app/b.py")
+	c := filepath.Join(root, "app/c.py")
+	init := filepath.Join(root, "app/__init__.py")
+
+	deps, err := trees.GetDependencies(file.CreatePaths(a), QueryOptions{MaxDepth: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := file.CreatePaths(b, init); !reflect.DeepEqual(deps, expected) {
+		t.Errorf("expected direct dependencies %v, got: %v", expected, deps)
+	}
+
+	deps, err = trees.GetDependencies(file.CreatePaths(a), QueryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := file.CreatePaths(b, c, init); !reflect.DeepEqual(deps, expected) {
+		t.Errorf("expected transitive dependencies %v, got: %v", expected, deps)
+	}
+}
+
+func TestClassToPath(t *testing.T) {
+	root, _ := filepath.EvalSymlinks(t.TempDir())
+	writeFile(t, filepath.Join(root, "app/__init__.py"), "")
+	writeFile(t, filepath.Join(root, "app/models.py"), "")
+	expected := map[string]string{
+		// a package, whether or not the class keeps the __init__ suffix
+		"app":          filepath.Join(root, "app/__init__.py"),
+		"app.__init__": filepath.Join(root, "app/__init__.py"),
+		"app.models":   filepath.Join(root, "app/models.py"),
+		// modules need not exist
+		"app.missing": filepath.Join(root, "app/missing.py"),
+	}
+	for class, path := range expected {
+		if found := ClassToPath(root, class); found != path {
+			t.Fatalf("Found unexpected path for %v: %v", class, found)
+		}
+		// the root is cleaned, as by filepath.Join
+		if found := ClassToPath(root+"/", class); found != path {
+			t.Fatalf("Found unexpected path for %v beneath %v/: %v", class, root, found)
+		}
+	}
+}
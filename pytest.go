@@ -0,0 +1,68 @@
+package pyast
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// PytestOptions controls which dependencies pytest creates.
+type PytestOptions struct {
+	// TestPatterns match the base names of test modules, which depend on
+	// every conftest.py in their directory and those above it within the
+	// root. Defaults to DefaultTestPatterns.
+	TestPatterns []string `json:"test_patterns,omitempty"`
+}
+
+func (o *PytestOptions) isTest(class string) bool {
+	patterns := o.TestPatterns
+	if len(patterns) == 0 {
+		patterns = DefaultTestPatterns
+	}
+	base := class[strings.LastIndex(class, ".")+1:] + ".py"
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, base); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// conftests finds the conftest.py modules which pytest loads for a module,
+// innermost first.
+func (t *tree) conftests(class string) []ResolvedImport {
+	var result []ResolvedImport
+	dir := class
+	for dir != "" {
+		if i := strings.LastIndex(dir, "."); i >= 0 {
+			dir = dir[:i]
+		} else {
+			dir = ""
+		}
+		conftest := "conftest"
+		if dir != "" {
+			conftest = dir + ".conftest"
+		}
+		if _, ok := t.modules[conftest]; ok && conftest != class {
+			result = append(result, ResolvedImport{Imported: conftest, Path: classFile(t.root, conftest), Resolution: ResolvedModule, Kind: ImportImplicit})
+		}
+	}
+	return result
+}
+
+// parsePytestPlugins finds the modules named by `pytest_plugins = [...]`,
+// which pytest imports when it loads the module declaring them.
+func parsePytestPlugins(statement []token) []importStatement {
+	if len(statement) < 3 || !statement[0].is(tokenName, "pytest_plugins") || !statement[1].is(tokenOp, "=") && !statement[1].is(tokenOp, "+=") {
+		return nil
+	}
+	var result []importStatement
+	for _, tok := range statement[2:] {
+		if tok.kind != tokenString {
+			continue
+		}
+		if value, ok := stringLiteral(tok.value); ok && reDottedPath.MatchString(value) {
+			result = append(result, importStatement{names: []string{value}, line: tok.line, kind: ImportImplicit})
+		}
+	}
+	return result
+}
@@ -0,0 +1,70 @@
+package pyast
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	file "github.com/nicois/file"
+)
+
+func TestPytestDependencies(t *testing.T) {
+	root, _ := filepath.EvalSymlinks(t.TempDir())
+	writeFile(t, filepath.Join(root, "fixtures/__init__.py"), "")
+	writeFile(t, filepath.Join(root, "fixtures/db.py"), "")
+	writeFile(t, filepath.Join(root, "tests/__init__.py"), "")
+	writeFile(t, filepath.Join(root, "tests/conftest.py"), "pytest_plugins = [\"fixtures.db\"]\n")
+	writeFile(t, filepath.Join(root, "tests/unit/__init__.py"), "")
+	writeFile(t, filepath.Join(root, "tests/unit/conftest.py"), "")
+	writeFile(t, filepath.Join(root, "tests/unit/test_a.py"), "")
+	writeFile(t, filepath.Join(root, "tests/unit/helper.py"), "")
+	writeFile(t, filepath.Join(root, "tests/test_b.py"), "")
+	conftest := filepath.Join(root, "tests/conftest.py")
+	unitConftest := filepath.Join(root, "tests/unit/conftest.py")
+	a := filepath.Join(root, "tests/unit/test_a.py")
+	b := filepath.Join(root, "tests/test_b.py")
+
+	trees, err := BuildTreesWithOptions(context.Background(), file.CreatePaths(root), BuildTreesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dependees, _ := trees.GetDependees(file.CreatePaths(conftest)); len(dependees) != 1 {
+		t.Fatalf("Found unexpected dependees without pytest: %v", dependees)
+	}
+
+	trees, err = BuildTreesWithOptions(context.Background(), file.CreatePaths(root), BuildTreesOptions{Pytest: &PytestOptions{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dependees, err := trees.GetDependees(file.CreatePaths(conftest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := file.CreatePaths(conftest, unitConftest, a, b); !reflect.DeepEqual(dependees, expected) {
+		t.Fatalf("Found unexpected dependees: %v", dependees)
+	}
+	dependees, err = trees.GetDependees(file.CreatePaths(unitConftest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := file.CreatePaths(unitConftest, a); !reflect.DeepEqual(dependees, expected) {
+		t.Fatalf("Found unexpected dependees: %v", dependees)
+	}
+	// pytest_plugins imports the plugin
+	dependees, err = trees.GetDependees(file.CreatePaths(filepath.Join(root, "fixtures/db.py")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := file.CreatePaths(filepath.Join(root, "fixtures/db.py"), conftest, unitConftest, a, b); !reflect.DeepEqual(dependees, expected) {
+		t.Fatalf("Found unexpected dependees: %v", dependees)
+	}
+	// which can be ignored
+	dependees, err = trees.GetDependeesWithOptions(file.CreatePaths(conftest), QueryOptions{Ignore: ImportImplicit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := file.CreatePaths(conftest); !reflect.DeepEqual(dependees, expected) {
+		t.Fatalf("Found unexpected dependees: %v", dependees)
+	}
+}
@@ -0,0 +1,467 @@
+package pyast
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	file "github.com/nicois/file"
+)
+
+// Resolution describes what an import statement refers to.
+type Resolution int
+
+const (
+	// ResolvedModule is a module named by the import, eg "import foo.bar"
+	// or "from foo import bar" where foo/bar.py exists.
+	ResolvedModule Resolution = iota
+	// ResolvedPackage is a package's __init__.py, which runs when the
+	// package, or anything within it, is imported.
+	ResolvedPackage
+	// ResolvedAttribute is a module (or package __init__.py) which defines the
+	// imported name, eg "from foo import bar" where bar is a function in foo.py.
+	ResolvedAttribute
+)
+
+func (r Resolution) String() string {
+	switch r {
+	case ResolvedModule:
+		return "module"
+	case ResolvedPackage:
+		return "package"
+	case ResolvedAttribute:
+		return "attribute"
+	}
+	return "unknown"
+}
+
+// ResolvedImport is an import which refers to a file in one of the trees.
+type ResolvedImport struct {
+	Imported   string     // the class of the imported file, as it was scanned
+	Path       string     // the imported file
+	Resolution Resolution // how the import refers to the file
+	Line       int        // line of the import statement
+	Kind       ImportKind // when the import statement is executed
+}
+
+// findModule finds the tree containing a scanned module with this exact class.
+func (t *trees) findModule(class string) (string, bool) {
+	for _, tree := range *t {
+		if _, ok := tree.modules[class]; ok {
+			return classFile(tree.root, class), true
+		}
+	}
+	return "", false
+}
+
+// resolveModule finds the module or package named by a dotted name.
+func (t *trees) resolveModule(dotted string) (ResolvedImport, bool) {
+	if path, ok := t.findModule(dotted); ok {
+		return ResolvedImport{Imported: dotted, Path: path, Resolution: ResolvedModule}, true
+	}
+	if path, ok := t.findModule(dotted + ".__init__"); ok {
+		return ResolvedImport{Imported: dotted + ".__init__", Path: path, Resolution: ResolvedPackage}, true
+	}
+	return ResolvedImport{}, false
+}
+
+// resolveName finds the files within the trees which an imported name
+// refers to. Importing a module also runs the __init__.py of each package
+// containing it, so those are included too.
+func (t *trees) resolveName(name importedName) []ResolvedImport {
+	if name.Name == "*" && name.Package != "" {
+		return t.resolveStar(name)
+	}
+	var result []ResolvedImport
+	dotted := name.dotted()
+	for i, c := range dotted {
+		if c != '.' {
+			continue
+		}
+		if path, ok := t.findModule(dotted[:i] + ".__init__"); ok {
+			result = append(result, ResolvedImport{Imported: dotted[:i] + ".__init__", Path: path, Resolution: ResolvedPackage, Line: name.Line, Kind: name.Kind})
+		}
+	}
+	if resolved, ok := t.resolveModule(dotted); ok {
+		resolved.Line = name.Line
+		resolved.Kind = name.Kind
+		return append(result, resolved)
+	}
+	if name.Kind&ImportStringRef != 0 {
+		// a reference may be to anything within a module, such as a method of a class
+		for i := strings.LastIndex(dotted, "."); i > 0; i = strings.LastIndex(dotted[:i], ".") {
+			if resolved, ok := t.resolveModule(dotted[:i]); ok {
+				resolved.Resolution = ResolvedAttribute
+				resolved.Line = name.Line
+				resolved.Kind = name.Kind
+				return append(result, resolved)
+			}
+		}
+		return result
+	}
+	if name.Package != "" {
+		// not a submodule, so it must be something defined within the package
+		if resolved, ok := t.resolveModule(name.Package); ok {
+			resolved.Resolution = ResolvedAttribute
+			resolved.Line = name.Line
+			resolved.Kind = name.Kind
+			result = append(result, resolved)
+		}
+	}
+	return result
+}
+
+// resolveStar resolves `from module import *`, which imports the module,
+// along with any submodules among the names it exports.
+func (t *trees) resolveStar(name importedName) []ResolvedImport {
+	result := t.resolveName(importedName{Name: name.Package, Line: name.Line, Kind: name.Kind})
+	module, ok := t.resolveModule(name.Package)
+	if !ok {
+		return result
+	}
+	for _, exported := range t.exports(module.Imported) {
+		if submodule, ok := t.resolveModule(name.Package + "." + exported); ok {
+			submodule.Line = name.Line
+			submodule.Kind = name.Kind
+			result = append(result, submodule)
+		}
+	}
+	return result
+}
+
+// exports finds the names which `from module import *` binds, for a scanned module.
+func (t *trees) exports(class string) []string {
+	for _, tree := range *t {
+		if _, ok := tree.modules[class]; ok {
+			return tree.symbols[class].exports()
+		}
+	}
+	return nil
+}
+
+// supersedes reports if r describes an import of the same file better than
+// other: it names the file rather than just its package, or it is earlier.
+func (r ResolvedImport) supersedes(other ResolvedImport) bool {
+	if (r.Resolution == ResolvedPackage) != (other.Resolution == ResolvedPackage) {
+		return other.Resolution == ResolvedPackage
+	}
+	return r.Line < other.Line
+}
+
+// resolve replaces each tree's resolved graph, checking every imported
+// name against the modules discovered in all the trees. After rescanning
+// some files, resolveRescanned does the same for only what they affect.
+func (t *trees) resolve() {
+	for i := range *t {
+		tree := &(*t)[i]
+		tree.resolved = make(map[string][]ResolvedImport)
+		tree.resolvedImporters = make(map[string]Classes)
+		for importer := range tree.names {
+			t.resolveImporter(tree, importer)
+		}
+	}
+}
+
+// resolveImporter replaces what a single module of tree resolves to,
+// removing it if it is no longer scanned.
+func (t *trees) resolveImporter(tree *tree, importer string) {
+	for _, resolved := range tree.resolved[importer] {
+		if importers, ok := tree.resolvedImporters[resolved.Imported]; ok {
+			delete(importers, importer)
+			if len(importers) == 0 {
+				delete(tree.resolvedImporters, resolved.Imported)
+			}
+		}
+	}
+	delete(tree.resolved, importer)
+	names, ok := tree.names[importer]
+	if !ok {
+		return
+	}
+	// keep one import of each kind for each file
+	type key struct {
+		path string
+		kind ImportKind
+	}
+	byPath := make(map[key]ResolvedImport)
+	add := func(resolved ResolvedImport) {
+		if resolved.Imported == importer {
+			// such as the package of a module which its __init__.py imports
+			return
+		}
+		k := key{path: resolved.Path, kind: resolved.Kind}
+		if existing, ok := byPath[k]; !ok || resolved.supersedes(existing) {
+			byPath[k] = resolved
+		}
+	}
+	for _, name := range names {
+		for _, resolved := range t.resolveName(name) {
+			add(resolved)
+		}
+	}
+	if tree.opts.Pytest != nil && tree.isPytestModule(importer) {
+		for _, resolved := range tree.conftests(importer) {
+			add(resolved)
+		}
+	}
+	for _, resolved := range t.implicitImports(classFile(tree.root, importer), tree.opts.ImplicitImports) {
+		add(resolved)
+	}
+	if len(byPath) == 0 {
+		return
+	}
+	imports := make([]ResolvedImport, 0, len(byPath))
+	for _, resolved := range byPath {
+		imports = append(imports, resolved)
+		importers, ok := tree.resolvedImporters[resolved.Imported]
+		if !ok {
+			importers = CreateClasses()
+			tree.resolvedImporters[resolved.Imported] = importers
+		}
+		importers.Add(importer)
+	}
+	sort.Slice(imports, func(i, j int) bool {
+		if imports[i].Path != imports[j].Path {
+			return imports[i].Path < imports[j].Path
+		}
+		return imports[i].Kind < imports[j].Kind
+	})
+	tree.resolved[importer] = imports
+}
+
+// isPytestModule reports if pytest makes class depend on its conftest.py files.
+func (t *tree) isPytestModule(class string) bool {
+	return t.opts.Pytest.isTest(class) || class == "conftest" || strings.HasSuffix(class, ".conftest")
+}
+
+// rescannedModule is what a rescanned module was like beforehand.
+type rescannedModule struct {
+	existed bool
+	exports []string
+}
+
+// beforeRescan records what the modules at paths are like, so that
+// resolveRescanned can tell what changed once they are rescanned.
+func (t *trees) beforeRescan(paths file.Paths) map[string]rescannedModule {
+	result := make(map[string]rescannedModule)
+	for path := range paths {
+		for _, tree := range *t {
+			if !strings.HasPrefix(path, tree.root+"/") {
+				continue
+			}
+			if class, err := PathToClass(path[len(tree.root)+1:]); err == nil {
+				result[class] = rescannedModule{existed: t.isModule(class), exports: t.exports(class)}
+			}
+		}
+	}
+	return result
+}
+
+// resolveRescanned updates the resolved graph after the modules recorded
+// by beforeRescan were rescanned. Only those modules are resolved again,
+// along with any importers which might refer to modules which were added
+// or removed, and the star importers of modules whose exports changed.
+func (t *trees) resolveRescanned(before map[string]rescannedModule) {
+	pending := make([]Classes, len(*t))
+	for i := range pending {
+		pending[i] = CreateClasses()
+	}
+	for class, previous := range before {
+		for i := range *t {
+			pending[i].Add(class)
+		}
+		if t.isModule(class) != previous.existed {
+			t.importersOfAddedOrRemoved(class, pending)
+		} else if !reflect.DeepEqual(t.exports(class), previous.exports) {
+			t.starImporters(moduleName(class), pending)
+		}
+	}
+	for i := range *t {
+		tree := &(*t)[i]
+		for importer := range pending[i] {
+			t.resolveImporter(tree, importer)
+		}
+	}
+}
+
+// importersOfAddedOrRemoved adds, to the pending importers of each tree,
+// those which might resolve differently now that class was added or removed.
+func (t *trees) importersOfAddedOrRemoved(class string, pending []Classes) {
+	module := moduleName(class)
+	for i := range *t {
+		tree := &(*t)[i]
+		pending[i].Union(tree.resolvedImporters[class])
+		// names of the module, its package, or anything within it
+		for candidate, node := range tree.nodes {
+			if candidate == module || candidate == module+".__init__" || strings.HasPrefix(candidate, module+".") {
+				pending[i].Union(node.importers)
+			}
+		}
+		if tree.opts.Pytest != nil && (module == "conftest" || strings.HasSuffix(module, ".conftest")) {
+			dir := strings.TrimSuffix(strings.TrimSuffix(module, "conftest"), ".")
+			for importer := range tree.modules {
+				if dir == "" || strings.HasPrefix(importer, dir+".") {
+					pending[i].Add(importer)
+				}
+			}
+		}
+		for pattern, imported := range tree.opts.ImplicitImports {
+			for _, path := range imported {
+				if target, ok := t.pathToClassAcrossTrees(path); !ok || target != module && target != module+".__init__" {
+					continue
+				}
+				for importer := range tree.modules {
+					if matched, _ := filepath.Match(pattern, classFile(tree.root, importer)); matched {
+						pending[i].Add(importer)
+					}
+				}
+			}
+		}
+	}
+	// a star import of the package may bind the module
+	if i := strings.LastIndex(module, "."); i > 0 {
+		t.starImporters(module[:i], pending)
+	}
+}
+
+// starImporters adds, to the pending importers of each tree, those which
+// star import the module.
+func (t *trees) starImporters(module string, pending []Classes) {
+	for i := range *t {
+		tree := &(*t)[i]
+		for _, candidate := range []string{module, module + ".__init__"} {
+			node, ok := tree.nodes[candidate]
+			if !ok {
+				continue
+			}
+			for importer := range node.importers {
+				for _, name := range tree.names[importer] {
+					if name.Name == "*" && name.Package == module {
+						pending[i].Add(importer)
+						break
+					}
+				}
+			}
+		}
+	}
+}
+
+// implicitImports finds the modules which path depends on, according to implicit.
+func (t *trees) implicitImports(path string, implicit map[string][]string) []ResolvedImport {
+	var result []ResolvedImport
+	for pattern, imported := range implicit {
+		if matched, _ := filepath.Match(pattern, path); !matched {
+			continue
+		}
+		for _, importedPath := range imported {
+			class, ok := t.pathToClassAcrossTrees(importedPath)
+			if !ok || importedPath == path {
+				continue
+			}
+			if modulePath, ok := t.findModule(class); ok && modulePath == importedPath {
+				result = append(result, ResolvedImport{Imported: class, Path: importedPath, Resolution: ResolvedModule, Kind: ImportImplicit})
+			}
+		}
+	}
+	return result
+}
+
+// getResolvedImportersAcrossTrees finds all classes whose imports resolve to
+// the given class, other than imports of the ignored kinds.
+func (t *trees) getResolvedImportersAcrossTrees(class string, ignore ImportKind) Classes {
+	result := CreateClasses()
+	for _, tree := range *t {
+		for importer := range tree.resolvedImporters[class] {
+			for _, imported := range tree.resolved[importer] {
+				if imported.Imported == class && !ignore.ignores(imported.Kind) {
+					result.Add(importer)
+					break
+				}
+			}
+		}
+	}
+	return result
+}
+
+// getRawImportersAcrossTrees finds all classes which might import the given
+// class, other than with imports of the ignored kinds.
+func (t *trees) getRawImportersAcrossTrees(class string, ignore ImportKind) Classes {
+	result := CreateClasses()
+	for importer := range t.getImportersAcrossTrees(class) {
+		for _, tree := range *t {
+			for _, name := range tree.names[importer] {
+				if ignore.ignores(name.Kind) {
+					continue
+				}
+				for _, candidate := range name.candidates() {
+					if candidate == class {
+						result.Add(importer)
+					}
+				}
+			}
+		}
+	}
+	return result
+}
+
+// getResolvedImportsAcrossTrees finds the resolved imports of the given
+// class, other than those of the ignored kinds.
+func (t *trees) getResolvedImportsAcrossTrees(class string, ignore ImportKind) []ResolvedImport {
+	var result []ResolvedImport
+	for _, tree := range *t {
+		for _, imported := range tree.resolved[class] {
+			if !ignore.ignores(imported.Kind) {
+				result = append(result, imported)
+			}
+		}
+	}
+	return result
+}
+
+// isModule reports if the class was scanned in any of the trees.
+func (t *trees) isModule(class string) bool {
+	_, ok := t.findModule(class)
+	return ok
+}
+
+// RawImports maps the path of each scanned module to every class its
+// imports might refer to, whether or not they exist. For example,
+// "from foo import bar" yields foo.bar, foo.bar.__init__ and foo.
+func (t *trees) RawImports() map[string]Classes {
+	result := make(map[string]Classes)
+	for _, tree := range *t {
+		for importer := range tree.modules {
+			classes := CreateClasses()
+			classes.Union(tree.imports[importer])
+			result[classFile(tree.root, importer)] = classes
+		}
+	}
+	return result
+}
+
+// ResolvedImports maps the path of each scanned module to the files in
+// the trees which it imports, sorted by path. A file imported by statements
+// of different kinds appears once for each kind. Imports of anything outside
+// the trees, such as third-party packages, are omitted.
+func (t *trees) ResolvedImports() map[string][]ResolvedImport {
+	result := make(map[string][]ResolvedImport)
+	for _, tree := range *t {
+		for importer := range tree.modules {
+			imports := tree.resolved[importer]
+			result[classFile(tree.root, importer)] = append([]ResolvedImport(nil), imports...)
+		}
+	}
+	return result
+}
+
+// resolvedPaths converts classes to the paths of the scanned modules.
+func (t *trees) resolvedPaths(classes Classes) file.Paths {
+	result := file.CreatePaths()
+	for class := range classes {
+		if path, ok := t.findModule(class); ok {
+			result.Add(path)
+		}
+	}
+	return result
+}
@@ -0,0 +1,87 @@
+package pyast
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	file "github.com/nicois/file"
+)
+
+func TestResolvedImports(t *testing.T) {
+	root, _ := filepath.EvalSymlinks(t.TempDir())
+	writeFile(t, filepath.Join(root, "app/__init__.py"), "VERSION = 1\n")
+	writeFile(t, filepath.Join(root, "app/models.py"), "class Thing: pass\n")
+	writeFile(t, filepath.Join(root, "app/sub/__init__.py"), "")
+	writeFile(t, filepath.Join(root, "app/sub/helpers.py"), "")
+	main := filepath.Join(root, "app/main.py")
+	writeFile(t, main, `import os
+from app.models import Thing
+from app import VERSION
+import app.sub.helpers
+from requests import get
+`)
+	trees, err := BuildTreesWithOptions(context.Background(), file.CreatePaths(root), BuildTreesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ResolvedImport{
+		{Imported: "app.__init__", Path: filepath.Join(root, "app/__init__.py"), Resolution: ResolvedAttribute, Line: 3},
+		{Imported: "app.models", Path: filepath.Join(root, "app/models.py"), Resolution: ResolvedAttribute, Line: 2},
+		{Imported: "app.sub.__init__", Path: filepath.Join(root, "app/sub/__init__.py"), Resolution: ResolvedPackage, Line: 4},
+		{Imported: "app.sub.helpers", Path: filepath.Join(root, "app/sub/helpers.py"), Resolution: ResolvedModule, Line: 4},
+	}
+	if resolved := trees.ResolvedImports()[main]; !reflect.DeepEqual(resolved, expected) {
+		t.Fatalf("Found unexpected resolved imports: %+v", resolved)
+	}
+
+	// the raw graph still has every candidate
+	raw := trees.RawImports()[main]
+	for _, class := range []string{"os", "app.models.Thing", "app.VERSION", "requests.get", "requests"} {
+		if _, ok := raw[class]; !ok {
+			t.Fatalf("expected %v in the raw imports: %v", class, raw)
+		}
+	}
+
+	// "from app import VERSION" is an attribute of app/__init__.py, so main depends on it
+	dependees, err := trees.GetDependees(file.CreatePaths(filepath.Join(root, "app/__init__.py")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dependees[main]; !ok {
+		t.Fatalf("Found unexpected dependees: %v", dependees)
+	}
+	// only main imports models
+	dependees, err = trees.GetDependees(file.CreatePaths(filepath.Join(root, "app/models.py")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dependees, file.CreatePaths(filepath.Join(root, "app/models.py"), main)) {
+		t.Fatalf("Found unexpected dependees: %v", dependees)
+	}
+}
+
+func TestPackageDoesNotImportItself(t *testing.T) {
+	root, _ := filepath.EvalSymlinks(t.TempDir())
+	init := filepath.Join(root, "pkg/__init__.py")
+	models := filepath.Join(root, "pkg/models.py")
+	writeFile(t, init, "from pkg.models import Model\n")
+	writeFile(t, models, "")
+	trees, err := BuildTreesWithOptions(context.Background(), file.CreatePaths(root), BuildTreesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ResolvedImport{{Imported: "pkg.models", Path: models, Resolution: ResolvedAttribute, Line: 1}}
+	if resolved := trees.ResolvedImports()[init]; !reflect.DeepEqual(resolved, expected) {
+		t.Fatalf("Found unexpected resolved imports: %+v", resolved)
+	}
+	dependencies, err := trees.GetDependencies(file.CreatePaths(init), QueryOptions{MaxDepth: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := file.CreatePaths(models); !reflect.DeepEqual(dependencies, expected) {
+		t.Fatalf("Found unexpected dependencies: %v", dependencies)
+	}
+}
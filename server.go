@@ -0,0 +1,314 @@
+package pyast
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	file "github.com/nicois/file"
+	log "github.com/sirupsen/logrus"
+)
+
+// Request is a query sent to a Server, as a single line of JSON.
+type Request struct {
+	// Command is one of "rdeps", "deps", "affected", "rescan" or "ping".
+	Command      string   `json:"command"`
+	Paths        []string `json:"paths,omitempty"`
+	Depth        int      `json:"depth,omitempty"`         // for "deps"
+	TestPatterns []string `json:"test_patterns,omitempty"` // for "affected"
+	Ignore       []string `json:"ignore,omitempty"`        // import kinds not to follow, eg "type-checking"
+	Canonical    bool     `json:"canonical,omitempty"`     // resolve symlinks in the result
+	// Symbols, for "rdeps" and "affected", are changed definitions to
+	// follow as well as Paths. Their paths must be absolute.
+	Symbols []Symbol `json:"symbols,omitempty"`
+}
+
+// Response is a Server's reply to a Request, as a single line of JSON.
+type Response struct {
+	Paths []string `json:"paths,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+// Server keeps trees in memory, updating them as files change, so that
+// queries can be answered without rebuilding the whole graph.
+type Server struct {
+	mutex *sync.RWMutex
+	trees *trees
+	opts  BuildTreesOptions
+}
+
+// NewServer builds the trees for the given roots. As with
+// BuildTreesWithOptions, a *BuildError may be returned alongside the server.
+func NewServer(ctx context.Context, pythonRoots file.Paths, opts BuildTreesOptions) (*Server, error) {
+	t, err := BuildTreesWithOptions(ctx, pythonRoots, opts)
+	if t == nil {
+		return nil, err
+	}
+	return &Server{mutex: new(sync.RWMutex), trees: t, opts: opts}, err
+}
+
+// Rescan re-reads the given files, replacing whatever they previously
+// imported. Files which no longer exist are removed from the graph.
+func (s *Server) Rescan(paths file.Paths) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var failures []*FileError
+	before := s.trees.beforeRescan(paths)
+	for path := range paths {
+		if err := s.trees.rescan(path, s.opts); err != nil {
+			failures = append(failures, &FileError{Path: path, Err: err})
+		}
+	}
+	s.trees.resolveRescanned(before)
+	if len(failures) > 0 {
+		return &BuildError{Errors: failures}
+	}
+	return nil
+}
+
+// rescanBelow rescans every file known to be beneath dir, which is
+// typically needed when the directory is removed or renamed.
+func (s *Server) rescanBelow(dir string) error {
+	paths := file.CreatePaths()
+	s.mutex.RLock()
+	for _, tree := range *s.trees {
+		for class := range tree.modules {
+			if path := classFile(tree.root, class); strings.HasPrefix(path, dir+"/") {
+				paths.Add(path)
+			}
+		}
+	}
+	s.mutex.RUnlock()
+	return s.Rescan(paths)
+}
+
+// classFile is the inverse of PathToClass.
+func classFile(root string, class string) string {
+	return filepath.Join(root, strings.ReplaceAll(class, ".", "/")+".py")
+}
+
+// contains reports if path would have been scanned when building the tree.
+func (t *tree) contains(path string, opts BuildTreesOptions) bool {
+	if !strings.HasPrefix(path, t.root+"/") || !strings.HasSuffix(path, ".py") {
+		return false
+	}
+	if t.ignorer == nil {
+		t.ignorer = newIgnorer(t.root, opts)
+	}
+	for dir := path; dir != t.root; dir = filepath.Dir(dir) {
+		if t.ignorer.ignores(dir, dir != path) {
+			return false
+		}
+		if dir != path && !opts.NamespacePackages && !file.FileExists(filepath.Join(dir, "__init__.py")) {
+			return false
+		}
+	}
+	return true
+}
+
+// rescan re-reads a single file in every tree containing it, replacing
+// whatever it previously imported. If the file no longer exists, it is forgotten.
+func (t *trees) rescan(path string, opts BuildTreesOptions) error {
+	var content []byte
+	var result error
+	for i := range *t {
+		tree := &(*t)[i]
+		if !strings.HasPrefix(path, tree.root+"/") {
+			continue
+		}
+		class, err := PathToClass(path[len(tree.root)+1:])
+		if err != nil {
+			continue
+		}
+		tree.removeModule(class)
+		if !file.FileExists(path) || !tree.contains(path, opts) {
+			continue
+		}
+		stat, _ := statFile(path)
+		if content == nil {
+			if content, err = file.ReadBytes(path); err != nil {
+				return err
+			}
+		}
+		source, err := decodeSource(content)
+		if err != nil {
+			log.Warnf("While decoding %v: %v", path, err)
+		}
+		names, symbols, err := extractModule(class, source, opts.parseOptions())
+		if err != nil {
+			result = err
+		}
+		tree.addModule(class, names)
+		tree.hashes[class] = contentHash(content)
+		tree.stats[class] = stat
+		tree.symbols[class] = symbols
+		canonical, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			canonical = path
+		}
+		tree.addCanonical(path, canonical)
+	}
+	return result
+}
+
+// Handle answers a single request.
+func (s *Server) Handle(request Request) Response {
+	var result file.Paths
+	var err error
+	paths := file.CreatePaths(request.Paths...)
+	var ignore ImportKind
+	for _, name := range request.Ignore {
+		kind, err := ParseImportKind(name)
+		if err != nil {
+			return Response{Error: err.Error()}
+		}
+		ignore |= kind
+	}
+	switch request.Command {
+	case "ping":
+		return Response{}
+	case "rescan":
+		err = s.Rescan(paths)
+	case "rdeps", "affected":
+		s.mutex.RLock()
+		result, err = s.trees.getDependeesOfChanges(paths, request.Symbols, QueryOptions{Ignore: ignore, Canonical: request.Canonical})
+		s.mutex.RUnlock()
+		if err == nil && request.Command == "affected" {
+			patterns := request.TestPatterns
+			if len(patterns) == 0 {
+				patterns = DefaultTestPatterns
+			}
+			result = FilterTests(result, patterns)
+		}
+	case "deps":
+		s.mutex.RLock()
+		result, err = s.trees.GetDependencies(paths, QueryOptions{MaxDepth: request.Depth, Ignore: ignore, Canonical: request.Canonical})
+		s.mutex.RUnlock()
+	default:
+		err = fmt.Errorf("unknown command %q", request.Command)
+	}
+	var response Response
+	if err != nil {
+		response.Error = err.Error()
+	}
+	for path := range result {
+		response.Paths = append(response.Paths, path)
+	}
+	sort.Strings(response.Paths)
+	return response
+}
+
+// Serve answers requests on the listener until ctx is cancelled.
+// Each connection may send any number of requests, one JSON object per line.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.serveConnection(conn)
+	}
+}
+
+func (s *Server) serveConnection(conn net.Conn) {
+	defer conn.Close()
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+	encoder := json.NewEncoder(conn)
+	for {
+		var request Request
+		if err := decoder.Decode(&request); err != nil {
+			if !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.EOF) {
+				encoder.Encode(Response{Error: err.Error()})
+			}
+			return
+		}
+		if err := encoder.Encode(s.Handle(request)); err != nil {
+			log.Debugf("While replying to %v: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// ListenAndServe watches the roots for changes (where supported) and
+// answers requests on a unix socket at socketPath, until ctx is cancelled.
+// A stale socket left behind by a previous server is replaced.
+func (s *Server) ListenAndServe(ctx context.Context, socketPath string) error {
+	if info, err := os.Lstat(socketPath); err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return fmt.Errorf("%v exists and is not a socket", socketPath)
+		}
+		if conn, err := net.Dial("unix", socketPath); err == nil {
+			conn.Close()
+			return fmt.Errorf("another server is already listening on %v", socketPath)
+		}
+		os.Remove(socketPath)
+	}
+	// start watching before listening, so no changes are missed
+	// by clients who have been able to connect
+	if w, err := newWatcher(s); err == nil {
+		go func() {
+			if err := w.run(ctx); err != nil {
+				log.Warnf("Stopped watching for changes: %v", err)
+			}
+		}()
+	} else {
+		log.Warnf("Not watching for changes, so clients must request rescans: %v", err)
+	}
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socketPath)
+	return s.Serve(ctx, listener)
+}
+
+// Watch rescans python files beneath the roots whenever they change,
+// until ctx is cancelled. It is only supported on linux.
+func (s *Server) Watch(ctx context.Context) error {
+	w, err := newWatcher(s)
+	if err != nil {
+		return err
+	}
+	return w.run(ctx)
+}
+
+// QueryServer sends a single request to the server listening at socketPath.
+func QueryServer(ctx context.Context, socketPath string, request Request) (Response, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", socketPath)
+	if err != nil {
+		return Response{}, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return Response{}, err
+	}
+	var response Response
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return Response{}, err
+	}
+	if response.Error != "" {
+		return response, errors.New(response.Error)
+	}
+	return response, nil
+}
@@ -0,0 +1,210 @@
+package pyast
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	file "github.com/nicois/file"
+)
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerRescan(t *testing.T) {
+	root, _ := filepath.EvalSymlinks(t.TempDir())
+	writeFile(t, filepath.Join(root, "app/__init__.py"), "")
+	writeFile(t, filepath.Join(root, "app/a.py"), "import os\n")
+	writeFile(t, filepath.Join(root, "app/b.py"), "")
+
+	server, err := NewServer(context.Background(), file.CreatePaths(root), BuildTreesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := filepath.Join(root, "app/b.py")
+	a := filepath.Join(root, "app/a.py")
+	if response := server.Handle(Request{Command: "rdeps", Paths: []string{b}}); !reflect.DeepEqual(response.Paths, []string{b}) {
+		t.Fatalf("Found unexpected response: %+v", response)
+	}
+
+	writeFile(t, a, "import app.b\n")
+	if err := server.Rescan(file.CreatePaths(a)); err != nil {
+		t.Fatal(err)
+	}
+	if response := server.Handle(Request{Command: "rdeps", Paths: []string{b}}); !reflect.DeepEqual(response.Paths, []string{a, b}) {
+		t.Fatalf("Found unexpected response after rescanning: %+v", response)
+	}
+
+	if err := os.Remove(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Rescan(file.CreatePaths(a)); err != nil {
+		t.Fatal(err)
+	}
+	if response := server.Handle(Request{Command: "rdeps", Paths: []string{b}}); !reflect.DeepEqual(response.Paths, []string{b}) {
+		t.Fatalf("Found unexpected response after deleting: %+v", response)
+	}
+}
+
+func TestServerRescanResolvesIncrementally(t *testing.T) {
+	root, _ := filepath.EvalSymlinks(t.TempDir())
+	path := func(name string) string {
+		return filepath.Join(root, "app", name+".py")
+	}
+	writeFile(t, path("__init__"), "")
+	writeFile(t, path("a"), "from app import b\n")
+	writeFile(t, path("base"), "")
+	writeFile(t, path("star"), "from app.tools import *\n")
+	writeFile(t, path("tools/__init__"), "")
+	writeFile(t, path("tools/z"), "")
+	writeFile(t, path("plugins/__init__"), "")
+	writeFile(t, path("plugins/x"), "import app.plugins.y\n")
+	writeFile(t, path("untouched"), "import app.base\n")
+
+	server, err := NewServer(context.Background(), file.CreatePaths(root), BuildTreesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := &(*server.trees)[0]
+	// only rescanned files, and those which they might affect, are resolved
+	// again, so this stays
+	stale := []ResolvedImport{{Imported: "app.base", Path: path("base"), Line: 99}}
+	tree.resolved["app.untouched"] = stale
+
+	// added, so a imports it rather than something within app
+	writeFile(t, path("b"), "")
+	// exports a submodule, so star imports it
+	writeFile(t, path("tools/__init__"), "__all__ = [\"z\"]\n")
+	writeFile(t, path("plugins/y"), "")
+	if err := os.Remove(path("plugins/__init__")); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Rescan(file.CreatePaths(path("b"), path("tools/__init__"), path("plugins/y"), path("plugins/__init__"))); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tree.resolved["app.untouched"], stale) {
+		t.Fatalf("Found unexpected imports of an untouched module: %+v", tree.resolved["app.untouched"])
+	}
+
+	// everything else is as if it had all been resolved again
+	incremental := tree.resolved
+	delete(incremental, "app.untouched")
+	server.trees.resolve()
+	delete(tree.resolved, "app.untouched")
+	if !reflect.DeepEqual(incremental, tree.resolved) {
+		t.Fatalf("Found unexpected imports:\n%+v\ninstead of:\n%+v", incremental, tree.resolved)
+	}
+	if expected := []ResolvedImport{{Imported: "app.__init__", Path: path("__init__"), Resolution: ResolvedPackage, Line: 1}, {Imported: "app.b", Path: path("b"), Line: 1}}; !reflect.DeepEqual(incremental["app.a"], expected) {
+		t.Fatalf("Found unexpected imports of a new module: %+v", incremental["app.a"])
+	}
+	if imports := incremental["app.star"]; len(imports) != 3 || imports[2].Imported != "app.tools.z" {
+		t.Fatalf("Found unexpected imports of an exported submodule: %+v", imports)
+	}
+}
+
+func TestServerSocketAndWatch(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("watching is only supported on linux")
+	}
+	root, _ := filepath.EvalSymlinks(t.TempDir())
+	writeFile(t, filepath.Join(root, "app/__init__.py"), "")
+	writeFile(t, filepath.Join(root, "app/b.py"), "")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server, err := NewServer(ctx, file.CreatePaths(root), BuildTreesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(t.TempDir(), "pyast.sock")
+	go func() {
+		if err := server.ListenAndServe(ctx, socket); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// once the server is listening, it is also watching
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := QueryServer(ctx, socket, Request{Command: "ping"}); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	b := filepath.Join(root, "app/b.py")
+	c := filepath.Join(root, "app/sub/c.py")
+	// a new package, which must be watched as well as scanned
+	writeFile(t, filepath.Join(root, "app/sub/__init__.py"), "")
+	writeFile(t, c, "from app import b\n")
+
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		response, err := QueryServer(ctx, socket, Request{Command: "rdeps", Paths: []string{b}})
+		if err == nil && reflect.DeepEqual(response.Paths, []string{b, c}) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the server did not notice the new file: %+v (%v)", response, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestServerWatchesSymlinkedPackages(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("watching is only supported on linux")
+	}
+	root, _ := filepath.EvalSymlinks(t.TempDir())
+	shared, _ := filepath.EvalSymlinks(t.TempDir())
+	writeFile(t, filepath.Join(root, "app/__init__.py"), "")
+	writeFile(t, filepath.Join(root, "app/b.py"), "")
+	writeFile(t, filepath.Join(shared, "__init__.py"), "")
+	if err := os.Symlink(shared, filepath.Join(root, "app/linked")); err != nil {
+		t.Fatal(err)
+	}
+	// a loop, which must not be followed forever
+	if err := os.Symlink(filepath.Join(root, "app"), filepath.Join(shared, "loop")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server, err := NewServer(ctx, file.CreatePaths(root), BuildTreesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher, err := newWatcher(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go watcher.run(ctx)
+
+	b := filepath.Join(root, "app/b.py")
+	c := filepath.Join(root, "app/linked/c.py")
+	// changed through the target, but scanned as it is reached from the root
+	writeFile(t, filepath.Join(shared, "c.py"), "from app import b\n")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		response := server.Handle(Request{Command: "rdeps", Paths: []string{b}})
+		if reflect.DeepEqual(response.Paths, []string{b, c}) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the server did not notice the new file: %+v", response)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,280 @@
+package pyast
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	file "github.com/nicois/file"
+	log "github.com/sirupsen/logrus"
+)
+
+// snapshotVersion is the version of the snapshot format written by Save.
+// Load rejects snapshots with any other version.
+const snapshotVersion = 2
+
+type snapshot struct {
+	Version int `json:"version"`
+	// Format is the cacheFormat of the names, which must also match
+	Format string         `json:"format"`
+	Trees  []snapshotTree `json:"trees"`
+}
+
+type snapshotTree struct {
+	Root    string            `json:"root"`
+	Options BuildTreesOptions `json:"options"`
+	Modules []snapshotModule  `json:"modules"`
+}
+
+type snapshotModule struct {
+	Class     string         `json:"class"`
+	Canonical string         `json:"canonical,omitempty"` // if the file was reached through a symlink
+	Hash      string         `json:"hash"`
+	Stat      fileStat       `json:"stat"`
+	Names     []importedName `json:"names"`
+	Symbols   moduleSymbols  `json:"symbols"`
+}
+
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// fileStat is what Refresh checks before hashing a file again.
+type fileStat struct {
+	Size    int64 `json:"size"`
+	ModTime int64 `json:"mtime"` // in nanoseconds since the epoch
+}
+
+func statFile(path string) (fileStat, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStat{}, err
+	}
+	return fileStat{Size: info.Size(), ModTime: info.ModTime().UnixNano()}, nil
+}
+
+// Save writes the trees as JSON, so that they can be loaded without
+// scanning every file again.
+func (t *trees) Save(w io.Writer) error {
+	result := snapshot{Version: snapshotVersion, Format: cacheFormat, Trees: []snapshotTree{}}
+	for _, tree := range *t {
+		saved := snapshotTree{Root: tree.root, Options: tree.opts, Modules: []snapshotModule{}}
+		classes := tree.modules.Lister()
+		sort.Strings(classes)
+		for _, class := range classes {
+			module := snapshotModule{Class: class, Hash: tree.hashes[class], Stat: tree.stats[class], Names: tree.names[class], Symbols: tree.symbols[class]}
+			path := classFile(tree.root, class)
+			if canonical, ok := tree.canonical[path]; ok && canonical != path {
+				module.Canonical = canonical
+			}
+			saved.Modules = append(saved.Modules, module)
+		}
+		result.Trees = append(result.Trees, saved)
+	}
+	return json.NewEncoder(w).Encode(result)
+}
+
+// Load reads trees written by Save. They are as they were when saved;
+// use Refresh to bring them up to date.
+func Load(r io.Reader) (*trees, error) {
+	var saved snapshot
+	if err := json.NewDecoder(r).Decode(&saved); err != nil {
+		return nil, fmt.Errorf("while reading snapshot: %w", err)
+	}
+	if saved.Version != snapshotVersion || saved.Format != cacheFormat {
+		return nil, fmt.Errorf("snapshot has version %v (%v), but only version %v (%v) is supported", saved.Version, saved.Format, snapshotVersion, cacheFormat)
+	}
+	result := make(trees, 0, len(saved.Trees))
+	for _, savedTree := range saved.Trees {
+		t := createTree(savedTree.Root)
+		t.opts = savedTree.Options
+		t.ignorer = newIgnorer(t.root, t.opts)
+		for _, module := range savedTree.Modules {
+			t.addModule(module.Class, module.Names)
+			t.hashes[module.Class] = module.Hash
+			t.stats[module.Class] = module.Stat
+			t.symbols[module.Class] = module.Symbols
+			path := classFile(t.root, module.Class)
+			canonical := module.Canonical
+			if canonical == "" {
+				canonical = path
+			}
+			t.addCanonical(path, canonical)
+		}
+		result = append(result, *t)
+	}
+	result.resolve()
+	return &result, nil
+}
+
+// Roots are the python roots of the trees.
+func (t *trees) Roots() file.Paths {
+	result := file.CreatePaths()
+	for _, tree := range *t {
+		result.Add(tree.root)
+	}
+	return result
+}
+
+// Refresh brings the trees up to date, typically after loading them.
+// Only files which were added or removed, or whose content changed, are
+// scanned. Files are only read to compare their content if their size or
+// modification time changed. As with BuildTreesWithOptions, the error may be a *BuildError
+// listing the files which could not be processed.
+func (t *trees) Refresh(ctx context.Context) error {
+	failures := createBuildErrors(nil)
+	for i := range *t {
+		tree := &(*t)[i]
+		if tree.ignorer == nil {
+			tree.ignorer = newIgnorer(tree.root, tree.opts)
+		}
+		changed := file.CreatePaths()
+		found := CreateClasses()
+		walkModules(ctx, tree.root, tree.opts, tree.ignorer, failures, func(path string, canonical string) {
+			class, err := PathToClass(path[len(tree.root)+1:])
+			if err != nil {
+				failures.add(path, err)
+				return
+			}
+			found.Add(class)
+			hash, ok := tree.hashes[class]
+			if !ok || tree.canonical[path] != canonical {
+				changed.Add(path)
+				return
+			}
+			stat, err := statFile(path)
+			if err != nil {
+				failures.add(path, err)
+				return
+			}
+			if stat == tree.stats[class] {
+				return
+			}
+			content, err := file.ReadBytes(path)
+			if err != nil {
+				failures.add(path, err)
+				return
+			}
+			if hash != contentHash(content) {
+				changed.Add(path)
+			} else {
+				// only touched, so there is no need to read it next time
+				tree.stats[class] = stat
+			}
+		})
+		for class := range tree.modules {
+			if _, ok := found[class]; !ok {
+				changed.Add(classFile(tree.root, class))
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		before := t.beforeRescan(changed)
+		for path := range changed {
+			if err := t.rescan(path, tree.opts); err != nil {
+				failures.add(path, err)
+			}
+		}
+		t.resolveRescanned(before)
+	}
+	return failures.err()
+}
+
+// BuildTreesWithSnapshot is like BuildTreesWithOptions, but starts from the
+// snapshot saved at path if it has the same roots, and the same options
+// which affect what is scanned, so that only
+// the files which changed since then are scanned. The snapshot is then
+// updated, ready for next time. If path is empty, it is the same as
+// BuildTreesWithOptions.
+func BuildTreesWithSnapshot(ctx context.Context, path string, pythonRoots file.Paths, opts BuildTreesOptions) (*trees, error) {
+	if path == "" {
+		return BuildTreesWithOptions(ctx, pythonRoots, opts)
+	}
+	result, err := loadSnapshot(path, pythonRoots, opts)
+	if err == nil {
+		err = result.Refresh(ctx)
+	} else {
+		log.Debugf("Building the trees, as the snapshot cannot be used: %v", err)
+		result, err = BuildTreesWithOptions(ctx, pythonRoots, opts)
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	if result == nil || err != nil && opts.FailFast {
+		return nil, err
+	}
+	if saveErr := saveSnapshot(path, result); saveErr != nil {
+		log.Warnf("Could not save the snapshot: %v", saveErr)
+	}
+	return result, err
+}
+
+// loadSnapshot loads the snapshot at path, if it was saved with the same
+// roots and the same options which affect what is scanned. The trees then
+// take on opts, as the others may differ.
+func loadSnapshot(path string, pythonRoots file.Paths, opts BuildTreesOptions) (*trees, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	result, err := Load(f)
+	if err != nil {
+		return nil, err
+	}
+	roots := file.CreatePaths()
+	for root := range pythonRoots {
+		absolute, err := filepath.Abs(root)
+		if err != nil {
+			return nil, err
+		}
+		roots.Add(absolute)
+	}
+	if !reflect.DeepEqual(result.Roots(), roots) {
+		return nil, fmt.Errorf("it has roots %v", result.Roots())
+	}
+	serialised, _ := json.Marshal(opts.scanningOptions())
+	for i := range *result {
+		tree := &(*result)[i]
+		if saved, _ := json.Marshal(tree.opts.scanningOptions()); !bytes.Equal(saved, serialised) {
+			return nil, fmt.Errorf("it has options %s", saved)
+		}
+		tree.opts = opts
+	}
+	return result, nil
+}
+
+// scanningOptions are the options without those which do not change the
+// scanned graph, so that changing them does not discard a snapshot.
+func (o BuildTreesOptions) scanningOptions() BuildTreesOptions {
+	o.FailFast = false
+	o.PythonVersion = ""
+	return o
+}
+
+// saveSnapshot replaces the snapshot at path, without leaving a partial file behind.
+func saveSnapshot(path string, t *trees) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := t.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
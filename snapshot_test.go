@@ -0,0 +1,118 @@
+package pyast
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	file "github.com/nicois/file"
+)
+
+func TestSnapshot(t *testing.T) {
+	root, _ := filepath.EvalSymlinks(t.TempDir())
+	writeFile(t, filepath.Join(root, "app/__init__.py"), "")
+	writeFile(t, filepath.Join(root, "app/models.py"), "")
+	writeFile(t, filepath.Join(root, "app/views.py"), "from . import models\n")
+	writeFile(t, filepath.Join(root, "app/urls.py"), "import app.views\n")
+	writeFile(t, filepath.Join(root, "app/old.py"), "import app.models\n")
+
+	trees, err := BuildTreesWithOptions(context.Background(), file.CreatePaths(root), BuildTreesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved bytes.Buffer
+	if err := trees.Save(&saved); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(bytes.NewReader(saved.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	models := file.CreatePaths(filepath.Join(root, "app/models.py"))
+	expected, _ := trees.GetDependees(models)
+	if dependees, _ := loaded.GetDependees(models); !reflect.DeepEqual(dependees, expected) {
+		t.Fatalf("Found unexpected dependees: %v", dependees)
+	}
+
+	// an unchanged file is not rescanned, so this stays
+	tree := &(*loaded)[0]
+	tree.names["app.urls"] = append(tree.names["app.urls"], importedName{Name: "app.models", Line: 2})
+	tree.addModule("app.urls", tree.names["app.urls"])
+	loaded.resolveImporter(tree, "app.urls")
+	// nor is one whose size and modification time are unchanged, nor
+	// one which was only touched
+	tree.hashes["app.models"] = "stale"
+	touched := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(root, "app/__init__.py"), touched, touched); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, filepath.Join(root, "app/views.py"), "")
+	writeFile(t, filepath.Join(root, "app/new.py"), "from app.models import User\n")
+	if err := os.Remove(filepath.Join(root, "app/old.py")); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	dependees, _ := loaded.GetDependees(models)
+	if expected := file.CreatePaths(filepath.Join(root, "app/models.py"), filepath.Join(root, "app/new.py"), filepath.Join(root, "app/urls.py")); !reflect.DeepEqual(dependees, expected) {
+		t.Fatalf("Found unexpected dependees after refreshing: %v", dependees)
+	}
+	if hash := tree.hashes["app.models"]; hash != "stale" {
+		t.Fatalf("Found unexpected hash for an unchanged file: %v", hash)
+	}
+	if stat, _ := statFile(filepath.Join(root, "app/__init__.py")); tree.stats["app.__init__"] != stat {
+		t.Fatalf("Found unexpected stat for a touched file: %+v", tree.stats["app.__init__"])
+	}
+
+	saved.Reset()
+	saved.WriteString(`{"version": 0, "trees": []}`)
+	if _, err := Load(&saved); err == nil {
+		t.Fatal("expected an error for an unsupported version")
+	}
+}
+
+func TestBuildTreesWithSnapshot(t *testing.T) {
+	root, _ := filepath.EvalSymlinks(t.TempDir())
+	writeFile(t, filepath.Join(root, "app/__init__.py"), "")
+	writeFile(t, filepath.Join(root, "app/models.py"), "")
+	writeFile(t, filepath.Join(root, "app/views.py"), "from . import models\n")
+	snapshot := filepath.Join(t.TempDir(), "snapshot.json")
+
+	for _, opts := range []BuildTreesOptions{{}, {}, {NamespacePackages: true}} {
+		trees, err := BuildTreesWithSnapshot(context.Background(), snapshot, file.CreatePaths(root), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dependees, _ := trees.GetDependees(file.CreatePaths(filepath.Join(root, "app/models.py")))
+		if expected := file.CreatePaths(filepath.Join(root, "app/models.py"), filepath.Join(root, "app/views.py")); !reflect.DeepEqual(dependees, expected) {
+			t.Fatalf("Found unexpected dependees: %v", dependees)
+		}
+		if _, err := loadSnapshot(snapshot, file.CreatePaths(root), opts); err != nil {
+			t.Fatalf("Found unexpected snapshot: %v", err)
+		}
+	}
+	if _, err := loadSnapshot(snapshot, file.CreatePaths(root), BuildTreesOptions{}); err == nil {
+		t.Fatal("expected the snapshot not to be used with other options")
+	}
+	// options which do not change what is scanned are taken on instead
+	opts := BuildTreesOptions{NamespacePackages: true, FailFast: true, PythonVersion: "3.9"}
+	loaded, err := loadSnapshot(snapshot, file.CreatePaths(root), opts)
+	if err != nil {
+		t.Fatalf("Found unexpected snapshot: %v", err)
+	}
+	if !reflect.DeepEqual((*loaded)[0].opts, opts) {
+		t.Fatalf("Found unexpected options: %+v", (*loaded)[0].opts)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if trees, err := BuildTreesWithSnapshot(ctx, snapshot, file.CreatePaths(root), opts); trees != nil || err != context.Canceled {
+		t.Fatalf("Found unexpected result when cancelled: %v, %v", trees, err)
+	}
+}
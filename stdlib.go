@@ -0,0 +1,116 @@
+package pyast
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultPythonVersion is the version whose standard library is recognised
+// if BuildTreesOptions.PythonVersion is not set.
+const DefaultPythonVersion = "3.12"
+
+// PythonVersions are the versions whose standard libraries are known.
+var PythonVersions = []string{"3.8", "3.9", "3.10", "3.11", "3.12", "3.13"}
+
+// stdlibModules are the top-level modules of the standard library in any of
+// PythonVersions, including private and platform-specific ones.
+var stdlibModules = map[string]bool{}
+
+func init() {
+	for _, name := range []string{
+		"__future__", "_abc", "_aix_support", "_ast", "_asyncio", "_bisect", "_blake2", "_bootlocale",
+		"_bootsubprocess", "_bz2", "_codecs", "_codecs_cn", "_codecs_hk", "_codecs_iso2022",
+		"_codecs_jp", "_codecs_kr", "_codecs_tw", "_collections", "_collections_abc", "_compat_pickle",
+		"_compression", "_contextvars", "_crypt", "_csv", "_ctypes", "_curses", "_curses_panel",
+		"_datetime", "_dbm", "_decimal", "_dummy_thread", "_elementtree", "_frozen_importlib",
+		"_frozen_importlib_external", "_functools", "_gdbm", "_hashlib", "_heapq", "_imp", "_io",
+		"_json", "_locale", "_lsprof", "_lzma", "_markupbase", "_md5", "_msi", "_multibytecodec",
+		"_multiprocessing", "_opcode", "_operator", "_osx_support", "_overlapped", "_pickle",
+		"_posixshmem", "_posixsubprocess", "_py_abc", "_pydecimal", "_pyio", "_queue", "_random",
+		"_scproxy", "_sha1", "_sha256", "_sha3", "_sha512", "_signal", "_sitebuiltins", "_socket",
+		"_sqlite3", "_sre", "_ssl", "_stat", "_statistics", "_string", "_strptime", "_struct",
+		"_symtable", "_thread", "_threading_local", "_tkinter", "_tokenize", "_tracemalloc", "_typing",
+		"_uuid", "_warnings", "_weakref", "_weakrefset", "_winapi", "_zoneinfo", "abc", "aifc",
+		"antigravity", "argparse", "array", "ast", "asynchat", "asyncio", "asyncore", "atexit",
+		"audioop", "base64", "bdb", "binascii", "binhex", "bisect", "builtins", "bz2", "cProfile",
+		"calendar", "cgi", "cgitb", "chunk", "cmath", "cmd", "code", "codecs", "codeop", "collections",
+		"colorsys", "compileall", "concurrent", "configparser", "contextlib", "contextvars", "copy",
+		"copyreg", "crypt", "csv", "ctypes", "curses", "dataclasses", "datetime", "dbm", "decimal",
+		"difflib", "dis", "distutils", "doctest", "dummy_threading", "email", "encodings", "ensurepip",
+		"enum", "errno", "faulthandler", "fcntl", "filecmp", "fileinput", "fnmatch", "formatter",
+		"fractions", "ftplib", "functools", "gc", "genericpath", "getopt", "getpass", "gettext", "glob",
+		"graphlib", "grp", "gzip", "hashlib", "heapq", "hmac", "html", "http", "idlelib", "imaplib",
+		"imghdr", "imp", "importlib", "inspect", "io", "ipaddress", "itertools", "json", "keyword",
+		"lib2to3", "linecache", "locale", "logging", "lzma", "mailbox", "mailcap", "marshal", "math",
+		"mimetypes", "mmap", "modulefinder", "msilib", "msvcrt", "multiprocessing", "netrc", "nis",
+		"nntplib", "nt", "ntpath", "nturl2path", "numbers", "opcode", "operator", "optparse", "os",
+		"ossaudiodev", "parser", "pathlib", "pdb", "pickle", "pickletools", "pipes", "pkgutil",
+		"platform", "plistlib", "poplib", "posix", "posixpath", "pprint", "profile", "pstats", "pty",
+		"pwd", "py_compile", "pyclbr", "pydoc", "pydoc_data", "pyexpat", "queue", "quopri", "random",
+		"re", "readline", "reprlib", "resource", "rlcompleter", "runpy", "sched", "secrets", "select",
+		"selectors", "shelve", "shlex", "shutil", "signal", "site", "smtpd", "smtplib", "sndhdr",
+		"socket", "socketserver", "spwd", "sqlite3", "sre_compile", "sre_constants", "sre_parse", "ssl",
+		"stat", "statistics", "string", "stringprep", "struct", "subprocess", "sunau", "symbol",
+		"symtable", "sys", "sysconfig", "syslog", "tabnanny", "tarfile", "telnetlib", "tempfile",
+		"termios", "textwrap", "this", "threading", "time", "timeit", "tkinter", "token", "tokenize",
+		"tomllib", "trace", "traceback", "tracemalloc", "tty", "turtle", "turtledemo", "types",
+		"typing", "unicodedata", "unittest", "urllib", "uu", "uuid", "venv", "warnings", "wave",
+		"weakref", "webbrowser", "winreg", "winsound", "wsgiref", "xdrlib", "xml", "xmlrpc", "zipapp",
+		"zipfile", "zipimport", "zlib", "zoneinfo",
+	} {
+		stdlibModules[name] = true
+	}
+}
+
+// stdlibAdded maps the modules which are not in every version to the minor
+// version of Python 3 which added them.
+var stdlibAdded = map[string]int{
+	"graphlib": 9, "zoneinfo": 9, "_zoneinfo": 9, "tomllib": 11,
+}
+
+// stdlibRemoved maps the modules which are not in every version to the
+// first minor version of Python 3 without them.
+var stdlibRemoved = map[string]int{
+	"_dummy_thread": 9, "dummy_threading": 9,
+	"_bootlocale": 10, "formatter": 10, "parser": 10, "symbol": 10, "binhex": 11,
+	"asynchat": 12, "asyncore": 12, "distutils": 12, "imp": 12, "smtpd": 12,
+	"aifc": 13, "audioop": 13, "cgi": 13, "cgitb": 13, "chunk": 13, "crypt": 13, "_crypt": 13,
+	"imghdr": 13, "lib2to3": 13, "mailcap": 13, "msilib": 13, "_msi": 13, "nis": 13, "nntplib": 13,
+	"ossaudiodev": 13, "pipes": 13, "sndhdr": 13, "spwd": 13, "sunau": 13, "telnetlib": 13,
+	"uu": 13, "xdrlib": 13,
+}
+
+// ParsePythonVersion interprets a version such as "3.11" or "3.11.4",
+// returning its minor version. "" means DefaultPythonVersion.
+func ParsePythonVersion(version string) (int, error) {
+	if version == "" {
+		version = DefaultPythonVersion
+	}
+	parts := strings.Split(version, ".")
+	if len(parts) >= 2 && parts[0] == "3" {
+		if minor, err := strconv.Atoi(parts[1]); err == nil {
+			for _, known := range PythonVersions {
+				if known == "3."+parts[1] {
+					return minor, nil
+				}
+			}
+		}
+	}
+	return 0, fmt.Errorf("unknown python version %q: expected one of %v", version, strings.Join(PythonVersions, ", "))
+}
+
+// isStdlib reports if a top-level module is in the standard library of the
+// given minor version of Python 3.
+func isStdlib(module string, minor int) bool {
+	if !stdlibModules[module] {
+		return false
+	}
+	if added, ok := stdlibAdded[module]; ok && minor < added {
+		return false
+	}
+	if removed, ok := stdlibRemoved[module]; ok && minor >= removed {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,121 @@
+package pyast
+
+import (
+	"regexp"
+	"strings"
+)
+
+// StringReferenceOptions controls which string literals are treated as
+// references to modules. Each reference resolves to the longest prefix
+// which is a known module, so "acme.billing.client.send" depends on
+// acme/billing/client.py.
+type StringReferenceOptions struct {
+	// Calls are functions whose first argument, or name= keyword argument,
+	// is a dotted path. A call matches if its callee is the same or ends
+	// with it, so "patch" matches mock.patch(...) and @patch(...).
+	Calls []string `json:"calls,omitempty"`
+
+	// Assignments are variables whose values contain dotted paths.
+	Assignments []string `json:"assignments,omitempty"`
+}
+
+// DefaultStringReferences recognises mock.patch targets, Django settings
+// and celery task names.
+var DefaultStringReferences = StringReferenceOptions{
+	Calls:       []string{"patch", "patch.object", "send_task", "signature", "task", "shared_task"},
+	Assignments: []string{"INSTALLED_APPS", "MIDDLEWARE"},
+}
+
+var reDottedPath = regexp.MustCompile(`^[A-Za-z_]\w*(\.[A-Za-z_]\w*)*$`)
+
+func (o *StringReferenceOptions) matchesCall(callee string) bool {
+	for _, call := range o.Calls {
+		if callee == call || strings.HasSuffix(callee, "."+call) {
+			return true
+		}
+	}
+	return false
+}
+
+// parse finds the string references within a statement.
+func (o *StringReferenceOptions) parse(statement []token) []importStatement {
+	var result []importStatement
+	add := func(tok token, path string) {
+		if reDottedPath.MatchString(path) {
+			result = append(result, importStatement{names: []string{path}, line: tok.line, kind: ImportStringRef})
+		}
+	}
+	addLiteral := func(tok token) {
+		if tok.kind != tokenString {
+			return
+		}
+		if value, ok := stringLiteral(tok.value); ok {
+			add(tok, value)
+		}
+	}
+
+	if len(statement) > 2 && statement[0].kind == tokenName {
+		for _, assignment := range o.Assignments {
+			if statement[0].value != assignment {
+				continue
+			}
+			if op := statement[1]; op.is(tokenOp, "=") || op.is(tokenOp, "+=") || op.is(tokenOp, ":") {
+				for _, tok := range statement[2:] {
+					addLiteral(tok)
+				}
+				return result
+			}
+		}
+	}
+
+	for i := 1; i+1 < len(statement); i++ {
+		if !statement[i].is(tokenOp, "(") || statement[i-1].kind != tokenName {
+			continue
+		}
+		callee, start := dottedNameEndingAt(statement, i-1)
+		if start > 0 && (statement[start-1].is(tokenName, "def") || statement[start-1].is(tokenName, "class")) {
+			continue
+		}
+		if !o.matchesCall(callee) {
+			continue
+		}
+		// the first argument, as a string or a dotted name such as patch.object(acme.client, "send")
+		if first := statement[i+1]; first.kind == tokenString {
+			if i+2 < len(statement) && (statement[i+2].is(tokenOp, ",") || statement[i+2].is(tokenOp, ")")) {
+				addLiteral(first)
+			}
+		} else if first.kind == tokenName {
+			name, rest := parseDottedName(statement[i+1:])
+			if strings.Contains(name, ".") && len(rest) > 0 && (rest[0].is(tokenOp, ",") || rest[0].is(tokenOp, ")")) {
+				add(first, name)
+			}
+		}
+		// a name= keyword argument, as used by celery tasks
+		depth := 0
+		for j := i + 1; j+2 < len(statement) && depth >= 0; j++ {
+			switch {
+			case statement[j].is(tokenOp, "("), statement[j].is(tokenOp, "["), statement[j].is(tokenOp, "{"):
+				depth++
+			case statement[j].is(tokenOp, ")"), statement[j].is(tokenOp, "]"), statement[j].is(tokenOp, "}"):
+				depth--
+			case depth == 0 && statement[j].is(tokenName, "name") && statement[j+1].is(tokenOp, "="):
+				addLiteral(statement[j+2])
+			}
+		}
+	}
+	return result
+}
+
+// dottedNameEndingAt returns the dotted name whose last part is
+// statement[end], and the index of its first part.
+func dottedNameEndingAt(statement []token, end int) (string, int) {
+	start := end
+	for start >= 2 && statement[start-1].is(tokenOp, ".") && statement[start-2].kind == tokenName {
+		start -= 2
+	}
+	parts := make([]string, 0, (end-start)/2+1)
+	for i := start; i <= end; i += 2 {
+		parts = append(parts, statement[i].value)
+	}
+	return strings.Join(parts, "."), start
+}
@@ -0,0 +1,96 @@
+package pyast
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	file "github.com/nicois/file"
+)
+
+func TestParseStringReferences(t *testing.T) {
+	source := `from unittest import mock
+
+INSTALLED_APPS = [
+    "django.contrib.admin",
+    "polls.apps.PollsConfig",
+]
+MIDDLEWARE += ["acme.middleware.Timing"]
+OTHER = ["acme.not_a_reference"]
+
+@mock.patch("acme.billing.client.send")
+def test_send(send):
+    with mock.patch.object(acme.billing.client, "send"):
+        app.send_task("acme.tasks.charge", args=[1])
+    patch(f"acme.{name}")
+    patch("not a path")
+
+@app.task(name="acme.tasks.refund")
+def refund():
+    pass
+`
+	refs := DefaultStringReferences
+	var found []string
+	for _, statement := range parseImportsAndReferences(source, parseOptions{StringReferences: &refs}) {
+		if statement.kind&ImportStringRef != 0 {
+			found = append(found, statement.names...)
+		}
+	}
+	expected := []string{
+		"django.contrib.admin",
+		"polls.apps.PollsConfig",
+		"acme.middleware.Timing",
+		"acme.billing.client.send",
+		"acme.billing.client",
+		"acme.tasks.charge",
+		"acme.tasks.refund",
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Fatalf("Found unexpected references: %v", found)
+	}
+
+	// references are opt-in
+	for _, statement := range parseImports(source) {
+		if statement.kind&ImportStringRef != 0 {
+			t.Fatalf("Found unexpected reference: %+v", statement)
+		}
+	}
+}
+
+func TestGetDependeesOfStringReferences(t *testing.T) {
+	root, _ := filepath.EvalSymlinks(t.TempDir())
+	writeFile(t, filepath.Join(root, "acme/__init__.py"), "")
+	writeFile(t, filepath.Join(root, "acme/client.py"), "class Client:\n    def send(self): pass\n")
+	test := filepath.Join(root, "acme/test_client.py")
+	writeFile(t, test, "from unittest import mock\n\n@mock.patch(\"acme.client.Client.send\")\ndef test_send(send): pass\n")
+	client := filepath.Join(root, "acme/client.py")
+
+	trees, err := BuildTreesWithOptions(context.Background(), file.CreatePaths(root), BuildTreesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dependees, _ := trees.GetDependees(file.CreatePaths(client)); len(dependees) != 1 {
+		t.Fatalf("Found unexpected dependees without string references: %v", dependees)
+	}
+
+	refs := DefaultStringReferences
+	trees, err = BuildTreesWithOptions(context.Background(), file.CreatePaths(root), BuildTreesOptions{StringReferences: &refs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ResolvedImport{
+		{Imported: "acme.__init__", Path: filepath.Join(root, "acme/__init__.py"), Resolution: ResolvedPackage, Line: 3, Kind: ImportStringRef},
+		{Imported: "acme.client", Path: client, Resolution: ResolvedAttribute, Line: 3, Kind: ImportStringRef},
+	}
+	if resolved := trees.ResolvedImports()[test]; !reflect.DeepEqual(resolved, expected) {
+		t.Fatalf("Found unexpected resolved imports: %+v", resolved)
+	}
+	dependees, err := trees.GetDependees(file.CreatePaths(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dependees, file.CreatePaths(client, test)) {
+		t.Fatalf("Found unexpected dependees: %v", dependees)
+	}
+}
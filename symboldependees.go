@@ -0,0 +1,291 @@
+package pyast
+
+import (
+	"sort"
+	"strings"
+
+	file "github.com/nicois/file"
+)
+
+// Symbol is a name bound at the top level of a module, such as a function,
+// class or variable. An empty name stands for the whole module.
+type Symbol struct {
+	Path string `json:"path"`
+	Name string `json:"name"`
+}
+
+// moduleTree finds the tree containing a scanned module with this exact class.
+func (t *trees) moduleTree(class string) (*tree, bool) {
+	for i := range *t {
+		if _, ok := (*t)[i].modules[class]; ok {
+			return &(*t)[i], true
+		}
+	}
+	return nil, false
+}
+
+// moduleName is the dotted name by which a module is imported.
+func moduleName(class string) string {
+	return strings.TrimSuffix(class, ".__init__")
+}
+
+// attributeOf finds the name within module which an import of an attribute
+// refers to, as in the slugify of `from acme.utils import slugify`.
+func attributeOf(module string, name importedName) string {
+	rest := strings.TrimPrefix(name.dotted(), moduleName(module)+".")
+	attribute, _, _ := strings.Cut(rest, ".")
+	return attribute
+}
+
+// DefinedSymbols maps the path of each scanned module to the names bound at
+// its top level, by definitions, assignments and imports.
+func (t *trees) DefinedSymbols() map[string][]string {
+	result := make(map[string][]string)
+	for _, tree := range *t {
+		for class := range tree.modules {
+			result[classFile(tree.root, class)] = append([]string(nil), tree.symbols[class].Names...)
+		}
+	}
+	return result
+}
+
+// ImportedSymbols maps the path of each scanned module to the symbols it
+// imports by name from other scanned modules, as in `from acme.utils import
+// slugify` or a star import, sorted by path and then name.
+func (t *trees) ImportedSymbols() map[string][]Symbol {
+	result := make(map[string][]Symbol)
+	for _, tree := range *t {
+		for importer := range tree.modules {
+			seen := make(map[Symbol]bool)
+			var symbols []Symbol
+			add := func(symbol Symbol) {
+				if !seen[symbol] {
+					seen[symbol] = true
+					symbols = append(symbols, symbol)
+				}
+			}
+			for _, name := range tree.names[importer] {
+				if name.Name == "*" {
+					if module, ok := t.resolveModule(name.Package); ok {
+						for _, exported := range t.exports(module.Imported) {
+							add(Symbol{Path: module.Path, Name: exported})
+						}
+					}
+					continue
+				}
+				for _, resolved := range t.resolveName(name) {
+					if resolved.Resolution == ResolvedAttribute {
+						add(Symbol{Path: resolved.Path, Name: attributeOf(resolved.Imported, name)})
+					}
+				}
+			}
+			sort.Slice(symbols, func(i, j int) bool {
+				if symbols[i].Path != symbols[j].Path {
+					return symbols[i].Path < symbols[j].Path
+				}
+				return symbols[i].Name < symbols[j].Name
+			})
+			result[classFile(tree.root, importer)] = symbols
+		}
+	}
+	return result
+}
+
+// GetDependeesOfSymbols is like GetDependeesWithOptions, but starts from the
+// symbols which changed rather than whole files. A module is only included
+// if it imports one of the changed symbols, or the whole module containing
+// one, and its own symbols which refer to them are treated as changed in
+// turn. A changed symbol used by a top-level statement, which runs when the
+// module is imported, changes the whole module.
+//
+// Modules scanned without BuildTreesOptions.Symbols are treated as changed
+// entirely whenever any of their symbols is. The paths containing the
+// symbols are always included.
+func (t *trees) GetDependeesOfSymbols(symbols []Symbol, opts QueryOptions) (file.Paths, error) {
+	type pending struct {
+		class string
+		name  string
+		depth int
+	}
+	type key struct {
+		class string
+		name  string
+	}
+	affected := CreateClasses()
+	whole := CreateClasses()
+	seen := make(map[key]bool)
+	var queue []pending
+
+	change := func(class string, name string, depth int) {
+		if opts.MaxDepth > 0 && depth > opts.MaxDepth {
+			return
+		}
+		affected.Add(class)
+		if k := (key{class: class, name: name}); !seen[k] {
+			seen[k] = true
+			queue = append(queue, pending{class: class, name: name, depth: depth})
+		}
+	}
+	var changeModule func(tree *tree, class string, depth int)
+	changeModule = func(tree *tree, class string, depth int) {
+		if opts.MaxDepth > 0 && depth > opts.MaxDepth {
+			return
+		}
+		if _, already := whole[class]; already {
+			return
+		}
+		whole.Add(class)
+		affected.Add(class)
+		for _, name := range tree.symbols[class].Names {
+			change(class, name, depth)
+		}
+		// anything which depends on the module without naming a symbol
+		for i := range *t {
+			importers := &(*t)[i]
+			for importer := range importers.resolvedImporters[class] {
+				for _, imported := range importers.resolved[importer] {
+					if imported.Imported == class && imported.Kind&ImportImplicit != 0 && !opts.Ignore.ignores(imported.Kind) {
+						changeModule(importers, importer, depth+1)
+					}
+				}
+			}
+		}
+	}
+
+	// paths which are not scanned modules, such as deleted files, have no
+	// symbols to follow, so they are treated as changed files instead
+	unscanned := file.CreatePaths()
+	for _, symbol := range symbols {
+		scanned := false
+		for class := range t.classesOf(symbol.Path) {
+			if tree, ok := t.moduleTree(class); !ok {
+				continue
+			} else if symbol.Name == "" {
+				changeModule(tree, class, 0)
+			} else {
+				change(class, symbol.Name, 0)
+			}
+			scanned = true
+		}
+		if !scanned {
+			unscanned.Add(symbol.Path)
+		}
+	}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		tree, ok := t.moduleTree(current.class)
+		if !ok {
+			continue
+		}
+		defined := tree.symbols[current.class]
+		if !tree.opts.Symbols {
+			changeModule(tree, current.class, current.depth)
+		}
+		for definition, references := range defined.References {
+			for _, reference := range references {
+				if reference == current.name {
+					change(current.class, definition, current.depth)
+					break
+				}
+			}
+		}
+		for _, use := range defined.Uses {
+			if use == current.name {
+				changeModule(tree, current.class, current.depth)
+				break
+			}
+		}
+
+		for i := range *t {
+			importers := &(*t)[i]
+			for importer := range importers.resolvedImporters[current.class] {
+				for _, name := range importers.names[importer] {
+					if opts.Ignore.ignores(name.Kind) {
+						continue
+					}
+					t.followSymbol(name, current.class, current.name, func(binding string) {
+						if binding == "" {
+							changeModule(importers, importer, current.depth+1)
+						} else {
+							change(importer, binding, current.depth+1)
+						}
+					})
+				}
+			}
+		}
+	}
+
+	result := t.resolvedPaths(affected)
+	if opts.Canonical {
+		result = t.canonicalPaths(result)
+	}
+	if len(unscanned) > 0 {
+		dependees, err := t.GetDependeesWithOptions(unscanned, opts)
+		if err != nil {
+			return nil, err
+		}
+		result.Union(dependees)
+		result.Union(unscanned)
+	}
+	return result, nil
+}
+
+// getDependeesOfChanges combines the dependees of whole files, which may
+// have been deleted, with those of changed symbols.
+func (t *trees) getDependeesOfChanges(paths file.Paths, symbols []Symbol, opts QueryOptions) (file.Paths, error) {
+	result := file.CreatePaths()
+	if len(paths) > 0 || len(symbols) == 0 {
+		dependees, err := t.GetDependeesWithOptions(paths, opts)
+		if err != nil {
+			return nil, err
+		}
+		result.Union(dependees)
+	}
+	if len(symbols) > 0 {
+		dependees, err := t.GetDependeesOfSymbols(symbols, opts)
+		if err != nil {
+			return nil, err
+		}
+		result.Union(dependees)
+	}
+	return result, nil
+}
+
+// followSymbol calls fn if an imported name depends on the named symbol of
+// module, with the name it binds in the importer, or "" if it binds nothing
+// which could be followed.
+func (t *trees) followSymbol(name importedName, module string, symbol string, fn func(binding string)) {
+	if name.Name == "*" {
+		// binds each exported name as itself, including submodules
+		resolved, ok := t.resolveModule(name.Package)
+		if !ok {
+			return
+		}
+		for _, exported := range t.exports(resolved.Imported) {
+			if resolved.Imported == module && exported == symbol {
+				fn(symbol)
+			} else if submodule, ok := t.resolveModule(name.Package + "." + exported); ok && submodule.Imported == module {
+				fn(exported)
+			}
+		}
+		return
+	}
+	for _, resolved := range t.resolveName(name) {
+		if resolved.Imported != module {
+			continue
+		}
+		switch {
+		case resolved.Resolution == ResolvedAttribute:
+			if attributeOf(module, name) == symbol {
+				fn(name.binding())
+			}
+		case resolved.Resolution == ResolvedPackage && name.dotted() != moduleName(module):
+			// only runs the package's __init__.py, without using its symbols
+		default:
+			// the whole module is bound, so any of its symbols may be used
+			fn(name.binding())
+		}
+	}
+}
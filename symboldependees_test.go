@@ -0,0 +1,108 @@
+package pyast
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	file "github.com/nicois/file"
+)
+
+func TestSymbolReferences(t *testing.T) {
+	symbols := parseSymbols(logicalStatements(tokenize(`import re
+from .utils import slugify as slug
+
+PATTERN = re.compile("-")
+
+@register(slug)
+def clean(value):
+    return PATTERN.sub("", value.strip())
+
+class Page(Base):
+    def title(self):
+        return slug(self.name)
+
+print(clean)
+`)), true)
+	expected := map[string][]string{
+		"PATTERN": {"PATTERN", "re"},
+		"clean":   {"register", "slug", "clean", "value", "PATTERN"},
+		"Page":    {"Page", "Base", "title", "self", "slug"},
+	}
+	if !reflect.DeepEqual(symbols.References, expected) {
+		t.Fatalf("Found unexpected references: %v", symbols.References)
+	}
+	if expected := []string{"print", "clean"}; !reflect.DeepEqual(symbols.Uses, expected) {
+		t.Fatalf("Found unexpected uses: %v", symbols.Uses)
+	}
+}
+
+func TestGetDependeesOfSymbols(t *testing.T) {
+	root, _ := filepath.EvalSymlinks(t.TempDir())
+	path := func(name string) string {
+		return filepath.Join(root, "acme", name+".py")
+	}
+	writeFile(t, path("__init__"), "")
+	writeFile(t, path("utils"), "import re\n\nPATTERN = re.compile(\"x\")\n\ndef slugify(value):\n    return PATTERN.sub(\"-\", value)\n\ndef titlecase(value):\n    return value.title()\n")
+	writeFile(t, path("text"), "from acme.utils import slugify\n\ndef make_slug(value):\n    return slugify(value)\n\ndef other():\n    pass\n")
+	writeFile(t, path("api"), "from acme.text import make_slug\n")
+	writeFile(t, path("views"), "from acme.text import other\n")
+	writeFile(t, path("titles"), "from acme.utils import titlecase\n")
+	writeFile(t, path("registry"), "import acme.utils\n\nHANDLERS = [acme.utils.titlecase]\n")
+	writeFile(t, path("handlers"), "from acme.registry import HANDLERS\n")
+	writeFile(t, path("reexport"), "from acme.utils import slugify as slug\n")
+	writeFile(t, path("consumer"), "from acme.reexport import slug\n")
+	writeFile(t, path("side"), "from acme.utils import slugify\n\nprint(slugify(\"x\"))\n\ndef unrelated():\n    pass\n")
+	writeFile(t, path("side_user"), "from acme.side import unrelated\n")
+	writeFile(t, path("deleted_user"), "from acme.deleted import thing\n")
+
+	changed := []Symbol{{Path: path("utils"), Name: "slugify"}}
+	trees, err := BuildTreesWithOptions(context.Background(), file.CreatePaths(root), BuildTreesOptions{Symbols: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dependees, err := trees.GetDependeesOfSymbols(changed, QueryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := file.CreatePaths(path("utils"), path("text"), path("api"), path("registry"), path("handlers"), path("reexport"), path("consumer"), path("side"), path("side_user"))
+	if !reflect.DeepEqual(dependees, expected) {
+		t.Fatalf("Found unexpected dependees: %v", dependees)
+	}
+
+	dependees, _ = trees.GetDependeesOfSymbols(changed, QueryOptions{MaxDepth: 1})
+	expected = file.CreatePaths(path("utils"), path("text"), path("registry"), path("reexport"), path("side"))
+	if !reflect.DeepEqual(dependees, expected) {
+		t.Fatalf("Found unexpected dependees: %v", dependees)
+	}
+
+	// a deleted file has no symbols, so its importers are followed instead
+	elsewhere := filepath.Join(t.TempDir(), "elsewhere.py")
+	dependees, err = trees.GetDependeesOfSymbols([]Symbol{{Path: path("deleted"), Name: "thing"}, {Path: elsewhere, Name: "x"}}, QueryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = file.CreatePaths(path("deleted"), path("deleted_user"), elsewhere)
+	if !reflect.DeepEqual(dependees, expected) {
+		t.Fatalf("Found unexpected dependees of a deleted file: %v", dependees)
+	}
+
+	if expected := []Symbol{{Path: path("utils"), Name: "slugify"}}; !reflect.DeepEqual(trees.ImportedSymbols()[path("text")], expected) {
+		t.Fatalf("Found unexpected imported symbols: %v", trees.ImportedSymbols()[path("text")])
+	}
+	if expected := []string{"slugify", "make_slug", "other"}; !reflect.DeepEqual(trees.DefinedSymbols()[path("text")], expected) {
+		t.Fatalf("Found unexpected defined symbols: %v", trees.DefinedSymbols()[path("text")])
+	}
+
+	// without the index, every importer is followed
+	trees, err = BuildTreesWithOptions(context.Background(), file.CreatePaths(root), BuildTreesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dependees, _ = trees.GetDependeesOfSymbols(changed, QueryOptions{})
+	expected, _ = trees.GetDependees(file.CreatePaths(path("utils")))
+	if !reflect.DeepEqual(dependees, expected) {
+		t.Fatalf("Found unexpected dependees: %v", dependees)
+	}
+}
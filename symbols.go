@@ -0,0 +1,352 @@
+package pyast
+
+import (
+	"strings"
+)
+
+// moduleSymbols are the names bound at the top level of a module.
+type moduleSymbols struct {
+	// Names are bound by definitions, assignments and imports, in the
+	// order they are first bound.
+	Names []string `json:"names,omitempty"`
+
+	// All are the names listed in __all__, if HasAll.
+	All    []string `json:"all,omitempty"`
+	HasAll bool     `json:"has_all,omitempty"`
+
+	// References maps each name bound by a definition or assignment to the
+	// names which it refers to, including within the bodies of functions
+	// and classes. Uses are the names which other top-level statements,
+	// which run when the module is imported, refer to. Both are only
+	// recorded if BuildTreesOptions.Symbols is set.
+	References map[string][]string `json:"references,omitempty"`
+	Uses       []string            `json:"uses,omitempty"`
+}
+
+// exports are the names which `from module import *` binds: those in
+// __all__ if it is defined, otherwise the names not starting with _.
+func (s moduleSymbols) exports() []string {
+	if s.HasAll {
+		return s.All
+	}
+	var result []string
+	for _, name := range s.Names {
+		if !strings.HasPrefix(name, "_") {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+var keywords = map[string]bool{
+	"False": true, "None": true, "True": true, "and": true, "as": true, "assert": true,
+	"async": true, "await": true, "break": true, "class": true, "continue": true,
+	"def": true, "del": true, "elif": true, "else": true, "except": true, "finally": true,
+	"for": true, "from": true, "global": true, "if": true, "import": true, "in": true,
+	"is": true, "lambda": true, "nonlocal": true, "not": true, "or": true, "pass": true,
+	"raise": true, "return": true, "try": true, "while": true, "with": true, "yield": true,
+}
+
+// parseSymbols finds the names bound at the top level of a module, which
+// includes the bodies of if and try statements, but not of functions or
+// classes. Names bound by loops, with statements and star imports are not
+// found. If references is set, it also finds what each definition refers to.
+func parseSymbols(statements [][]token, references bool) moduleSymbols {
+	var result moduleSymbols
+	seen := make(map[string]bool)
+	bind := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			result.Names = append(result.Names, name)
+		}
+	}
+	var current, decorators []string
+	for i, blocks := range enclosingBlocks(statements) {
+		statement := statements[i]
+		if withinDefinition(blocks) {
+			if references {
+				result.refer(current, referencedNames(statement))
+			}
+			continue
+		}
+		if statement[0].is(tokenName, "__all__") {
+			result.parseAll(statement)
+		}
+		names := boundNames(statement)
+		for _, name := range names {
+			bind(name)
+		}
+		if !references {
+			continue
+		}
+		current = nil
+		switch first := statement[0]; {
+		case first.is(tokenName, "import") || first.is(tokenName, "from"):
+		case first.is(tokenOp, "@"):
+			decorators = append(decorators, referencedNames(statement)...)
+			continue
+		case len(names) > 0:
+			result.refer(names, append(decorators, referencedNames(statement)...))
+			// as in `a = registry[key] = value`, which also changes registry
+			result.Uses = appendNew(result.Uses, mutatedNames(statement)...)
+			if defines(statement) {
+				// the body of a def or class follows
+				current = names
+			}
+		default:
+			result.Uses = appendNew(result.Uses, referencedNames(statement)...)
+		}
+		decorators = nil
+	}
+	return result
+}
+
+// refer records that each of the definitions refers to the names.
+func (s *moduleSymbols) refer(definitions []string, names []string) {
+	for _, definition := range definitions {
+		if s.References == nil {
+			s.References = make(map[string][]string)
+		}
+		s.References[definition] = appendNew(s.References[definition], names...)
+	}
+}
+
+// appendNew appends the values which are not already in slice.
+func appendNew(slice []string, values ...string) []string {
+	for _, value := range values {
+		found := false
+		for _, existing := range slice {
+			if existing == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			slice = append(slice, value)
+		}
+	}
+	return slice
+}
+
+// defines reports if a statement is the header of a def or class statement.
+func defines(statement []token) bool {
+	if statement[0].is(tokenName, "async") && len(statement) > 1 {
+		statement = statement[1:]
+	}
+	return statement[0].is(tokenName, "def") || statement[0].is(tokenName, "class")
+}
+
+// referencedNames finds the names a statement refers to, other than
+// keywords and attributes, as in the c of `a.c`.
+func referencedNames(statement []token) []string {
+	var result []string
+	for j, tok := range statement {
+		if tok.kind != tokenName || keywords[tok.value] {
+			continue
+		}
+		if j > 0 && statement[j-1].is(tokenOp, ".") {
+			continue
+		}
+		result = appendNew(result, tok.value)
+	}
+	return result
+}
+
+// withinDefinition reports if any of the blocks is the body of a function or class.
+func withinDefinition(blocks []*block) bool {
+	for _, b := range blocks {
+		if b.keyword == "def" || b.keyword == "class" {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAll records the string literals assigned to, or added to, __all__.
+func (s *moduleSymbols) parseAll(statement []token) {
+	if len(statement) < 3 {
+		return
+	}
+	var values []token
+	switch op := statement[1]; {
+	case op.is(tokenOp, "="):
+		s.All = nil
+		values = statement[2:]
+	case op.is(tokenOp, ":"):
+		// an annotated assignment
+		s.All = nil
+		for j, tok := range statement {
+			if tok.is(tokenOp, "=") {
+				values = statement[j+1:]
+				break
+			}
+		}
+	case op.is(tokenOp, "+="):
+		values = statement[2:]
+	case op.is(tokenOp, ".") && (statement[2].is(tokenName, "extend") || statement[2].is(tokenName, "append")):
+		values = statement[3:]
+	default:
+		return
+	}
+	s.HasAll = true
+	for _, tok := range values {
+		if tok.kind != tokenString {
+			continue
+		}
+		if value, ok := stringLiteral(tok.value); ok && reDottedPath.MatchString(value) && !strings.Contains(value, ".") {
+			s.All = append(s.All, value)
+		}
+	}
+}
+
+// boundNames finds the names which a simple statement, or the header of a
+// def or class statement, binds.
+func boundNames(statement []token) []string {
+	first := statement[0]
+	if first.is(tokenName, "async") && len(statement) > 1 {
+		statement = statement[1:]
+		first = statement[0]
+	}
+	if first.kind != tokenName {
+		return nil
+	}
+	switch first.value {
+	case "def", "class":
+		if len(statement) > 1 && statement[1].kind == tokenName {
+			return []string{statement[1].value}
+		}
+		return nil
+	case "import":
+		return importedBindings(statement[1:], false)
+	case "from":
+		for j, tok := range statement {
+			if tok.is(tokenName, "import") && j > 0 {
+				return importedBindings(statement[j+1:], true)
+			}
+		}
+		return nil
+	}
+	if keywords[first.value] {
+		return nil
+	}
+	if len(statement) > 1 && statement[1].is(tokenOp, ":") {
+		// an annotated assignment, or just an annotation
+		return []string{first.value}
+	}
+	var result []string
+	for _, target := range assignmentTargets(statement) {
+		names, _ := targetNames(target)
+		result = append(result, names...)
+	}
+	return result
+}
+
+// mutatedNames finds the names whose items or attributes a simple statement
+// assigns to, as in the registry of `registry[key] = value`.
+func mutatedNames(statement []token) []string {
+	if statement[0].kind != tokenName || keywords[statement[0].value] {
+		return nil
+	}
+	var result []string
+	for _, target := range assignmentTargets(statement) {
+		_, bases := targetNames(target)
+		result = appendNew(result, bases...)
+	}
+	return result
+}
+
+// assignmentTargets splits the targets from an assignment statement. Each
+// target is followed by =, as in `a = b, c = value`.
+func assignmentTargets(statement []token) [][]token {
+	var result [][]token
+	depth := 0
+	start := 0
+	for j, tok := range statement {
+		if tok.kind != tokenOp {
+			continue
+		}
+		switch tok.value {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+		case "=":
+			if depth == 0 {
+				result = append(result, statement[start:j])
+				start = j + 1
+			}
+		}
+	}
+	return result
+}
+
+// importedBindings finds the names bound by the rest of an import
+// statement, after `import`.
+func importedBindings(rest []token, from bool) []string {
+	var result []string
+	for len(rest) > 0 {
+		if rest[0].is(tokenOp, "(") {
+			rest = rest[1:]
+			continue
+		}
+		if rest[0].kind != tokenName {
+			break
+		}
+		name, remaining := parseDottedName(rest)
+		if name == "" {
+			break
+		}
+		if !from {
+			// `import a.b` binds a
+			name, _, _ = strings.Cut(name, ".")
+		}
+		if len(remaining) >= 2 && remaining[0].is(tokenName, "as") && remaining[1].kind == tokenName {
+			name = remaining[1].value
+			remaining = remaining[2:]
+		}
+		result = append(result, name)
+		if len(remaining) == 0 || !remaining[0].is(tokenOp, ",") {
+			break
+		}
+		rest = remaining[1:]
+	}
+	return result
+}
+
+// targetNames finds the names assigned to by an assignment target, and the
+// bases of its subscripts and attributes, as in the a of `a.b[c]`, which are
+// used rather than assigned to.
+func targetNames(target []token) (names, bases []string) {
+	depth := 0 // within a subscript or call, whose names are not targets
+	for j, tok := range target {
+		if tok.kind == tokenOp {
+			switch tok.value {
+			case "[", "(":
+				if depth > 0 || j > 0 && (target[j-1].kind == tokenName || target[j-1].is(tokenOp, ")") || target[j-1].is(tokenOp, "]")) {
+					depth++
+				}
+			case "]", ")":
+				if depth > 0 {
+					depth--
+				}
+			}
+			continue
+		}
+		if tok.kind != tokenName {
+			continue
+		}
+		if keywords[tok.value] {
+			// not a target, as in `f = lambda x=1: x`
+			return nil, nil
+		}
+		if depth > 0 || j > 0 && target[j-1].is(tokenOp, ".") {
+			continue
+		}
+		if j+1 < len(target) && (target[j+1].is(tokenOp, ".") || target[j+1].is(tokenOp, "[") || target[j+1].is(tokenOp, "(")) {
+			bases = appendNew(bases, tok.value)
+			continue
+		}
+		names = append(names, tok.value)
+	}
+	return names, bases
+}
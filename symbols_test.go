@@ -0,0 +1,125 @@
+package pyast
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	file "github.com/nicois/file"
+)
+
+func TestParseSymbols(t *testing.T) {
+	symbols := parseSymbols(logicalStatements(tokenize(`"""Docstring."""
+import os.path
+import json as _json
+from .models import User, Group as UserGroup
+from .views import *
+
+VERSION = "1.0"
+a, (b, c) = 1, (2, 3)
+d = e = 4
+f.attribute = 5
+g[0] = 6
+h: int = 7
+handler = lambda x=1: x
+print(VERSION == a)
+
+def slugify(value):
+    local = value
+    return local
+
+async def fetch():
+    pass
+
+@decorator
+class Thing(Base):
+    field = 1
+
+    def method(self):
+        inner = 2
+
+if TYPE_CHECKING:
+    from typing import Any
+try:
+    import ujson
+except ImportError:
+    ujson = None
+`)), false)
+	expected := []string{"os", "_json", "User", "UserGroup", "VERSION", "a", "b", "c", "d", "e", "h", "handler", "slugify", "fetch", "Thing", "Any", "ujson"}
+	if !reflect.DeepEqual(symbols.Names, expected) {
+		t.Fatalf("Found unexpected names: %v", symbols.Names)
+	}
+	if symbols.HasAll {
+		t.Fatalf("Found unexpected __all__: %v", symbols.All)
+	}
+	if exports := symbols.exports(); len(exports) != len(expected)-1 {
+		t.Fatalf("Found unexpected exports: %v", exports)
+	}
+
+	symbols = parseSymbols(logicalStatements(tokenize(`from .models import User
+__all__ = ["User", "views"]
+__all__ += ("helpers",)
+__all__.append("_private")
+__all__.extend(other.__all__)
+`)), false)
+	if expected := []string{"User", "views", "helpers", "_private"}; !symbols.HasAll || !reflect.DeepEqual(symbols.exports(), expected) {
+		t.Fatalf("Found unexpected exports: %v", symbols.exports())
+	}
+}
+
+func TestSubscriptTargets(t *testing.T) {
+	symbols := parseSymbols(logicalStatements(tokenize(`registry = {}
+registry[key] = value
+x.y[z] = 1
+a = cache[b] = 2
+c, d[e] = 3, 4
+`)), true)
+	if expected := []string{"registry", "a", "c"}; !reflect.DeepEqual(symbols.Names, expected) {
+		t.Fatalf("Found unexpected names: %v", symbols.Names)
+	}
+	// assigning to an item or attribute uses the name it belongs to
+	if expected := []string{"registry", "key", "value", "x", "z", "cache", "d"}; !reflect.DeepEqual(symbols.Uses, expected) {
+		t.Fatalf("Found unexpected uses: %v", symbols.Uses)
+	}
+}
+
+func TestStarImports(t *testing.T) {
+	root, _ := filepath.EvalSymlinks(t.TempDir())
+	writeFile(t, filepath.Join(root, "acme/__init__.py"), "__all__ = [\"models\", \"VERSION\"]\nVERSION = 1\n")
+	writeFile(t, filepath.Join(root, "acme/models.py"), "class User: pass\n")
+	writeFile(t, filepath.Join(root, "acme/views.py"), "")
+	writeFile(t, filepath.Join(root, "acme/api/__init__.py"), "")
+	writeFile(t, filepath.Join(root, "acme/api/handlers.py"), "from ..models import *\n")
+	writeFile(t, filepath.Join(root, "acme/api/routes.py"), "from acme import *\n")
+	trees, err := BuildTreesWithOptions(context.Background(), file.CreatePaths(root), BuildTreesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handlers := filepath.Join(root, "acme/api/handlers.py")
+	routes := filepath.Join(root, "acme/api/routes.py")
+	dependees, err := trees.GetDependees(file.CreatePaths(filepath.Join(root, "acme/models.py")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := file.CreatePaths(filepath.Join(root, "acme/models.py"), handlers, routes); !reflect.DeepEqual(dependees, expected) {
+		t.Fatalf("Found unexpected dependees: %v", dependees)
+	}
+	// views is not in __all__, so the star import does not import it
+	dependees, err = trees.GetDependees(file.CreatePaths(filepath.Join(root, "acme/views.py")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := file.CreatePaths(filepath.Join(root, "acme/views.py")); !reflect.DeepEqual(dependees, expected) {
+		t.Fatalf("Found unexpected dependees: %v", dependees)
+	}
+
+	expected := []ResolvedImport{
+		{Imported: "acme.__init__", Path: filepath.Join(root, "acme/__init__.py"), Resolution: ResolvedPackage, Line: 1},
+		{Imported: "acme.models", Path: filepath.Join(root, "acme/models.py"), Resolution: ResolvedModule, Line: 1},
+	}
+	if resolved := trees.ResolvedImports()[routes]; !reflect.DeepEqual(resolved, expected) {
+		t.Fatalf("Found unexpected resolved imports: %+v", resolved)
+	}
+}
@@ -0,0 +1,153 @@
+package pyast
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	file "github.com/nicois/file"
+	log "github.com/sirupsen/logrus"
+)
+
+// walkFunc is called for each file and directory found by walkFollowingSymlinks.
+// path is how the entry was reached, and canonical is path with every symlink resolved.
+type walkFunc func(path string, canonical string, d fs.DirEntry, err error) error
+
+// walkFollowingSymlinks is like filepath.WalkDir, but also descends into
+// symlinked directories, and describes symlinks by their targets.
+// A symlink to a directory which contains the link, or which is already
+// being walked, would be a loop so it is skipped.
+func walkFollowingSymlinks(root string, fn walkFunc) error {
+	canonical, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return fn(root, root, nil, err)
+	}
+	err = walkBelow(root, canonical, nil, fn)
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// walkBelow walks the directory canonical, which was reached as root.
+// following are the canonical directories already being walked, and the
+// symlinks within them which were followed to get here.
+func walkBelow(root string, canonical string, following []string, fn walkFunc) error {
+	following = append(following[:len(following):len(following)], canonical)
+	return filepath.WalkDir(canonical, func(path string, d fs.DirEntry, err error) error {
+		walked := root + path[len(canonical):]
+		if path == canonical && len(following) > 1 {
+			// the symlink itself was already reported
+			return nil
+		}
+		if err != nil || d.Type()&fs.ModeSymlink == 0 {
+			return fn(walked, path, d, err)
+		}
+		target, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return fn(walked, path, d, err)
+		}
+		info, err := os.Stat(target)
+		if err != nil {
+			return fn(walked, target, d, err)
+		}
+		err = fn(walked, target, followedEntry{DirEntry: d, info: info}, nil)
+		if !info.IsDir() || err != nil {
+			if err == fs.SkipDir {
+				// the symlink is not a directory to WalkDir
+				return nil
+			}
+			return err
+		}
+		for _, dir := range append(following, path) {
+			if dir == target || strings.HasPrefix(dir, target+"/") {
+				log.Debugf("Not following %v to %v, as it would be a loop.", walked, target)
+				return nil
+			}
+		}
+		return walkBelow(walked, target, append(following, path), fn)
+	})
+}
+
+// followedEntry describes a symlink by its target.
+type followedEntry struct {
+	fs.DirEntry
+	info fs.FileInfo
+}
+
+func (e followedEntry) IsDir() bool {
+	return e.info.IsDir()
+}
+
+func (e followedEntry) Type() fs.FileMode {
+	return e.info.Mode().Type()
+}
+
+func (e followedEntry) Info() (fs.FileInfo, error) {
+	return e.info, nil
+}
+
+// addCanonical records the path of a scanned module with symlinks resolved.
+func (t *tree) addCanonical(path string, canonical string) {
+	t.canonical[path] = canonical
+	walked, ok := t.walked[canonical]
+	if !ok {
+		walked = file.CreatePaths()
+		t.walked[canonical] = walked
+	}
+	walked.Add(path)
+}
+
+func (t *tree) removeCanonical(path string) {
+	canonical, ok := t.canonical[path]
+	if !ok {
+		return
+	}
+	delete(t.canonical, path)
+	delete(t.walked[canonical], path)
+	if len(t.walked[canonical]) == 0 {
+		delete(t.walked, canonical)
+	}
+}
+
+// canonicalPath resolves the symlinks in a path. Paths of scanned modules
+// are resolved as they were when scanned, and others using the filesystem.
+func (t *trees) canonicalPath(path string) string {
+	for _, tree := range *t {
+		if canonical, ok := tree.canonical[path]; ok {
+			return canonical
+		}
+	}
+	if canonical, err := filepath.EvalSymlinks(path); err == nil {
+		return canonical
+	}
+	return path
+}
+
+// classesOf finds the classes of the file at path. A file which was
+// reached through several symlinks has a class for each, and the path
+// may be canonical, or any of the ways it was reached.
+func (t *trees) classesOf(path string) Classes {
+	result := CreateClasses()
+	paths := file.CreatePaths(path)
+	canonical := t.canonicalPath(path)
+	for _, tree := range *t {
+		paths.Union(tree.walked[canonical])
+	}
+	for path := range paths {
+		if class, ok := t.pathToClassAcrossTrees(path); ok {
+			result.Add(class)
+		}
+	}
+	return result
+}
+
+// canonicalPaths resolves the symlinks in each path.
+func (t *trees) canonicalPaths(paths file.Paths) file.Paths {
+	result := file.CreatePaths()
+	for path := range paths {
+		result.Add(t.canonicalPath(path))
+	}
+	return result
+}
@@ -0,0 +1,78 @@
+package pyast
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	file "github.com/nicois/file"
+)
+
+func symlink(t *testing.T, target string, link string) {
+	t.Helper()
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuildTreesFollowsSymlinks(t *testing.T) {
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+	shared := filepath.Join(dir, "shared")
+	writeFile(t, filepath.Join(shared, "__init__.py"), "")
+	writeFile(t, filepath.Join(shared, "models.py"), "")
+	writeFile(t, filepath.Join(shared, "helpers.py"), "from . import models\n")
+
+	root := filepath.Join(dir, "src")
+	writeFile(t, filepath.Join(root, "app/__init__.py"), "")
+	writeFile(t, filepath.Join(root, "app/views.py"), "import app.shared.helpers\n")
+	writeFile(t, filepath.Join(root, "app/api.py"), "from lib import models\n")
+	writeFile(t, filepath.Join(dir, "real_tasks.py"), "import app.shared.models\n")
+	symlink(t, shared, filepath.Join(root, "app/shared"))
+	symlink(t, shared, filepath.Join(root, "lib"))
+	symlink(t, filepath.Join(dir, "real_tasks.py"), filepath.Join(root, "app/tasks.py"))
+	// loops
+	symlink(t, root, filepath.Join(shared, "src"))
+	symlink(t, filepath.Join(root, "app"), filepath.Join(root, "app/again"))
+
+	trees, err := BuildTreesWithOptions(context.Background(), file.CreatePaths(root), BuildTreesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same file is reached as app.shared.models and lib.models
+	for _, path := range []string{filepath.Join(shared, "models.py"), filepath.Join(root, "app/shared/models.py"), filepath.Join(root, "lib/models.py")} {
+		dependees, err := trees.GetDependees(file.CreatePaths(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := file.CreatePaths(
+			filepath.Join(root, "app/shared/models.py"),
+			filepath.Join(root, "app/shared/helpers.py"),
+			filepath.Join(root, "app/views.py"),
+			filepath.Join(root, "app/tasks.py"),
+			filepath.Join(root, "lib/models.py"),
+			filepath.Join(root, "lib/helpers.py"),
+			filepath.Join(root, "app/api.py"),
+		)
+		if !reflect.DeepEqual(dependees, expected) {
+			t.Fatalf("Found unexpected dependees of %v: %v", path, dependees)
+		}
+
+		dependees, err = trees.GetDependeesWithOptions(file.CreatePaths(path), QueryOptions{Canonical: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected = file.CreatePaths(
+			filepath.Join(shared, "models.py"),
+			filepath.Join(shared, "helpers.py"),
+			filepath.Join(root, "app/views.py"),
+			filepath.Join(dir, "real_tasks.py"),
+			filepath.Join(root, "app/api.py"),
+		)
+		if !reflect.DeepEqual(dependees, expected) {
+			t.Fatalf("Found unexpected canonical dependees of %v: %v", path, dependees)
+		}
+	}
+}
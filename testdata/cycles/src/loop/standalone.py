@@ -0,0 +1 @@
+import loop.x
@@ -0,0 +1,2 @@
+"""x imports y"""
+import loop.y
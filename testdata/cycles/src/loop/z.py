@@ -0,0 +1 @@
+from .x import thing
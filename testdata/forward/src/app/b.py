@@ -0,0 +1 @@
+import app.c
@@ -0,0 +1,498 @@
+package pyast
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+type tokenType int
+
+const (
+	tokenEndMarker tokenType = iota
+	tokenName
+	tokenNumber
+	tokenString
+	tokenOp
+	tokenNewline // end of a logical line
+	tokenNL      // non-logical line break: blank lines, or inside brackets
+	tokenComment
+	tokenIndent
+	tokenDedent
+)
+
+func (t tokenType) String() string {
+	switch t {
+	case tokenEndMarker:
+		return "ENDMARKER"
+	case tokenName:
+		return "NAME"
+	case tokenNumber:
+		return "NUMBER"
+	case tokenString:
+		return "STRING"
+	case tokenOp:
+		return "OP"
+	case tokenNewline:
+		return "NEWLINE"
+	case tokenNL:
+		return "NL"
+	case tokenComment:
+		return "COMMENT"
+	case tokenIndent:
+		return "INDENT"
+	case tokenDedent:
+		return "DEDENT"
+	}
+	return fmt.Sprintf("tokenType(%d)", int(t))
+}
+
+type token struct {
+	kind   tokenType
+	value  string
+	line   int // 1-based line of the first character
+	column int // 0-based byte offset within that line
+	start  int // byte offset into the source
+	end    int
+}
+
+func (t token) is(kind tokenType, value string) bool {
+	return t.kind == kind && t.value == value
+}
+
+// operators are matched longest-first.
+var operators = [][]string{
+	{"**=", "//=", ">>=", "<<=", "..."},
+	{"**", "//", ">>", "<<", "<=", ">=", "==", "!=", "->", ":=", "+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=", "@="},
+	{"+", "-", "*", "/", "%", "@", "&", "|", "^", "~", "<", ">", "(", ")", "[", "]", "{", "}", ",", ":", ";", ".", "=", "!"},
+}
+
+// stringPrefixes are the (lower-cased) prefixes which may precede a string literal.
+var stringPrefixes = map[string]bool{
+	"r": true, "u": true, "b": true, "f": true, "t": true,
+	"br": true, "rb": true, "fr": true, "rf": true, "tr": true, "rt": true,
+}
+
+type tokenizer struct {
+	source      string
+	pos         int
+	lineStarts  []int
+	indents     []int
+	parenDepth  int
+	atLineStart bool
+	tokens      []token
+}
+
+// tokenize splits python source into tokens, in the same spirit as
+// python's own tokenize module. It is deliberately lenient: malformed
+// input (eg unterminated strings) is tokenized as well as possible rather
+// than rejected, as we only need enough structure to find imports.
+func tokenize(source string) []token {
+	t := &tokenizer{source: source, indents: []int{0}, atLineStart: true}
+	t.lineStarts = []int{0}
+	for i := 0; i < len(source); i++ {
+		if source[i] == '\n' {
+			t.lineStarts = append(t.lineStarts, i+1)
+		} else if source[i] == '\r' && (i+1 == len(source) || source[i+1] != '\n') {
+			t.lineStarts = append(t.lineStarts, i+1)
+		}
+	}
+	t.run()
+	return t.tokens
+}
+
+func (t *tokenizer) emit(kind tokenType, start int, end int) {
+	line := sort.Search(len(t.lineStarts), func(i int) bool { return t.lineStarts[i] > start })
+	t.tokens = append(t.tokens, token{
+		kind:   kind,
+		value:  t.source[start:end],
+		line:   line,
+		column: start - t.lineStarts[line-1],
+		start:  start,
+		end:    end,
+	})
+}
+
+func (t *tokenizer) lastKind() tokenType {
+	if len(t.tokens) == 0 {
+		return tokenNewline
+	}
+	return t.tokens[len(t.tokens)-1].kind
+}
+
+func (t *tokenizer) run() {
+	src := t.source
+	for t.pos < len(src) {
+		if t.atLineStart && t.parenDepth == 0 {
+			if !t.indentation() {
+				continue
+			}
+		}
+		c := src[t.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\f':
+			t.pos++
+		case c == '#':
+			end := t.pos
+			for end < len(src) && src[end] != '\n' && src[end] != '\r' {
+				end++
+			}
+			t.emit(tokenComment, t.pos, end)
+			t.pos = end
+		case c == '\\' && t.pos+1 < len(src) && (src[t.pos+1] == '\n' || src[t.pos+1] == '\r'):
+			// explicit line continuation
+			t.pos = t.skipNewline(t.pos + 1)
+		case c == '\n' || c == '\r':
+			end := t.skipNewline(t.pos)
+			if t.parenDepth > 0 {
+				t.emit(tokenNL, t.pos, end)
+			} else {
+				t.emit(tokenNewline, t.pos, end)
+				t.atLineStart = true
+			}
+			t.pos = end
+		case isIdentifierStart(src, t.pos):
+			start := t.pos
+			end := t.identifierEnd(start)
+			if end < len(src) && (src[end] == '"' || src[end] == '\'') && stringPrefixes[strings.ToLower(src[start:end])] {
+				t.pos = t.stringEnd(start, end)
+				t.emit(tokenString, start, t.pos)
+				continue
+			}
+			t.emit(tokenName, start, end)
+			t.pos = end
+		case isDigit(c) || (c == '.' && t.pos+1 < len(src) && isDigit(src[t.pos+1])):
+			start := t.pos
+			t.pos = t.numberEnd(start)
+			t.emit(tokenNumber, start, t.pos)
+		case c == '"' || c == '\'':
+			start := t.pos
+			t.pos = t.stringEnd(start, start)
+			t.emit(tokenString, start, t.pos)
+		default:
+			t.operator()
+		}
+	}
+	if k := t.lastKind(); k != tokenNewline && k != tokenNL && k != tokenDedent && k != tokenIndent {
+		t.emit(tokenNewline, len(src), len(src))
+	}
+	for len(t.indents) > 1 {
+		t.indents = t.indents[:len(t.indents)-1]
+		t.emit(tokenDedent, len(src), len(src))
+	}
+	t.emit(tokenEndMarker, len(src), len(src))
+}
+
+// indentation is called at the start of each physical line which is not
+// inside brackets. It emits INDENT/DEDENT tokens as required, or consumes
+// the line entirely if it is blank or only contains a comment.
+// It returns false if the line was consumed.
+func (t *tokenizer) indentation() bool {
+	src := t.source
+	column := 0
+	pos := t.pos
+	for ; pos < len(src); pos++ {
+		switch src[pos] {
+		case ' ':
+			column++
+			continue
+		case '\t':
+			column = (column/8 + 1) * 8
+			continue
+		case '\f':
+			column = 0
+			continue
+		}
+		break
+	}
+	if pos == len(src) {
+		t.pos = pos
+		return false
+	}
+	if src[pos] == '#' || src[pos] == '\n' || src[pos] == '\r' {
+		// blank line, or comment-only line: no change in indentation
+		end := pos
+		for end < len(src) && src[end] != '\n' && src[end] != '\r' {
+			end++
+		}
+		if end > pos {
+			t.emit(tokenComment, pos, end)
+		}
+		if end < len(src) {
+			next := t.skipNewline(end)
+			t.emit(tokenNL, end, next)
+			end = next
+		}
+		t.pos = end
+		return false
+	}
+	t.pos = pos
+	t.atLineStart = false
+	current := t.indents[len(t.indents)-1]
+	if column > current {
+		t.indents = append(t.indents, column)
+		t.emit(tokenIndent, t.lineStartOf(pos), pos)
+		return true
+	}
+	for column < current && len(t.indents) > 1 {
+		t.indents = t.indents[:len(t.indents)-1]
+		current = t.indents[len(t.indents)-1]
+		t.emit(tokenDedent, pos, pos)
+	}
+	return true
+}
+
+func (t *tokenizer) lineStartOf(pos int) int {
+	i := sort.Search(len(t.lineStarts), func(i int) bool { return t.lineStarts[i] > pos })
+	return t.lineStarts[i-1]
+}
+
+func (t *tokenizer) skipNewline(pos int) int {
+	if t.source[pos] == '\r' && pos+1 < len(t.source) && t.source[pos+1] == '\n' {
+		return pos + 2
+	}
+	return pos + 1
+}
+
+func (t *tokenizer) operator() {
+	src := t.source
+	for _, candidates := range operators {
+		for _, op := range candidates {
+			if strings.HasPrefix(src[t.pos:], op) {
+				switch op {
+				case "(", "[", "{":
+					t.parenDepth++
+				case ")", "]", "}":
+					if t.parenDepth > 0 {
+						t.parenDepth--
+					}
+				}
+				t.emit(tokenOp, t.pos, t.pos+len(op))
+				t.pos += len(op)
+				return
+			}
+		}
+	}
+	// something unexpected, such as `$` or a stray non-identifier rune.
+	_, size := utf8.DecodeRuneInString(src[t.pos:])
+	t.emit(tokenOp, t.pos, t.pos+size)
+	t.pos += size
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentifierStart(src string, pos int) bool {
+	c := src[pos]
+	if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+		return true
+	}
+	if c < utf8.RuneSelf {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(src[pos:])
+	return unicode.IsLetter(r) || unicode.Is(unicode.Nl, r)
+}
+
+func (t *tokenizer) identifierEnd(pos int) int {
+	src := t.source
+	for pos < len(src) {
+		c := src[pos]
+		if c == '_' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+			pos++
+			continue
+		}
+		if c < utf8.RuneSelf {
+			break
+		}
+		r, size := utf8.DecodeRuneInString(src[pos:])
+		if !(unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.In(r, unicode.Mn, unicode.Mc, unicode.Nl, unicode.Pc)) {
+			break
+		}
+		pos += size
+	}
+	return pos
+}
+
+func (t *tokenizer) numberEnd(pos int) int {
+	src := t.source
+	if src[pos] == '0' && pos+1 < len(src) && strings.ContainsRune("xXoObB", rune(src[pos+1])) {
+		pos += 2
+		for pos < len(src) && (src[pos] == '_' || isDigit(src[pos]) || strings.ContainsRune("abcdefABCDEF", rune(src[pos]))) {
+			pos++
+		}
+		return pos
+	}
+	for pos < len(src) {
+		c := src[pos]
+		switch {
+		case isDigit(c) || c == '_' || c == '.':
+			pos++
+		case c == 'e' || c == 'E':
+			pos++
+			if pos < len(src) && (src[pos] == '+' || src[pos] == '-') {
+				pos++
+			}
+		case c == 'j' || c == 'J':
+			return pos + 1
+		default:
+			return pos
+		}
+	}
+	return pos
+}
+
+// stringEnd returns the offset just beyond the string literal whose prefix
+// starts at `start` and whose opening quote is at `quote`.
+func (t *tokenizer) stringEnd(start int, quote int) int {
+	prefix := strings.ToLower(t.source[start:quote])
+	formatted := strings.ContainsAny(prefix, "ft")
+	return t.stringBodyEnd(quote, formatted)
+}
+
+func (t *tokenizer) stringBodyEnd(quote int, formatted bool) int {
+	src := t.source
+	q := src[quote]
+	delimiter := src[quote : quote+1]
+	if strings.HasPrefix(src[quote:], strings.Repeat(string(q), 3)) {
+		delimiter = strings.Repeat(string(q), 3)
+	}
+	triple := len(delimiter) == 3
+	pos := quote + len(delimiter)
+	for pos < len(src) {
+		c := src[pos]
+		switch {
+		case c == '\\':
+			// escapes (including in raw strings) prevent the next character
+			// from terminating the string
+			pos += 2
+			if pos > len(src) {
+				pos = len(src)
+			}
+			continue
+		case strings.HasPrefix(src[pos:], delimiter):
+			return pos + len(delimiter)
+		case (c == '\n' || c == '\r') && !triple:
+			// unterminated single-quoted string; give up at the end of the line
+			return pos
+		case formatted && c == '{':
+			if pos+1 < len(src) && src[pos+1] == '{' {
+				pos += 2
+				continue
+			}
+			pos = t.replacementFieldEnd(pos + 1)
+			continue
+		}
+		pos++
+	}
+	return pos
+}
+
+// replacementFieldEnd skips an f-string replacement field, which may
+// itself contain brackets and (since python 3.12) strings using any quote.
+func (t *tokenizer) replacementFieldEnd(pos int) int {
+	src := t.source
+	depth := 1
+	for pos < len(src) && depth > 0 {
+		c := src[pos]
+		switch {
+		case c == '{' || c == '[' || c == '(':
+			depth++
+		case c == '}' || c == ']' || c == ')':
+			depth--
+		case c == '"' || c == '\'':
+			start := pos
+			for start > 0 && isIdentifierStart(src, start-1) {
+				start--
+			}
+			if !stringPrefixes[strings.ToLower(src[start:pos])] {
+				start = pos
+			}
+			pos = t.stringEnd(start, pos)
+			continue
+		}
+		pos++
+	}
+	return pos
+}
+
+func isTripleQuoted(literal string) bool {
+	body := strings.TrimLeft(literal, "rRuUbBfFtT")
+	return strings.HasPrefix(body, `"""`) || strings.HasPrefix(body, `'''`)
+}
+
+// stringLiteral returns the value of a string token, if it is a plain
+// literal without escapes or replacement fields.
+func stringLiteral(literal string) (string, bool) {
+	body := strings.TrimLeft(literal, "rRuU")
+	raw := len(body) < len(literal) && strings.ContainsAny(literal[:len(literal)-len(body)], "rR")
+	quote := 1
+	if isTripleQuoted(body) {
+		quote = 3
+	}
+	if len(body) < 2*quote || body[0] != '"' && body[0] != '\'' {
+		return "", false
+	}
+	value := body[quote : len(body)-quote]
+	if !raw && strings.Contains(value, "\\") {
+		return "", false
+	}
+	return value, true
+}
+
+var reCodingDeclaration = regexp.MustCompile(`^[ \t\f]*#.*?coding[:=][ \t]*([-\w.]+)`)
+
+// decodeSource converts the raw bytes of a python file to a string, honouring
+// a UTF-8 byte order mark or a PEP 263 encoding declaration in the first two lines.
+func decodeSource(content []byte) (string, error) {
+	if bytes.HasPrefix(content, []byte("\xef\xbb\xbf")) {
+		return string(content[3:]), nil
+	}
+	lines := bytes.SplitN(content, []byte("\n"), 3)
+	for i, line := range lines {
+		if i >= 2 {
+			break
+		}
+		match := reCodingDeclaration.FindSubmatch(line)
+		if match == nil {
+			if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 && trimmed[0] != '#' {
+				// the declaration must be on the first line, or on the
+				// second line if the first is blank or a comment
+				break
+			}
+			continue
+		}
+		name := normaliseEncoding(string(match[1]))
+		if name == "utf-8" {
+			return string(content), nil
+		}
+		encoding, err := htmlindex.Get(name)
+		if err != nil {
+			return string(content), fmt.Errorf("unknown encoding %q: %w", match[1], err)
+		}
+		decoded, err := encoding.NewDecoder().Bytes(content)
+		if err != nil {
+			return string(content), fmt.Errorf("while decoding as %v: %w", name, err)
+		}
+		return string(decoded), nil
+	}
+	return string(content), nil
+}
+
+func normaliseEncoding(name string) string {
+	name = strings.ReplaceAll(strings.ToLower(name), "_", "-")
+	switch {
+	case name == "utf8" || name == "utf-8" || strings.HasPrefix(name, "utf-8-"):
+		return "utf-8"
+	case name == "latin-1" || name == "iso-latin-1" || strings.HasPrefix(name, "latin-1-") || strings.HasPrefix(name, "iso-8859-1-"):
+		return "iso-8859-1"
+	}
+	return name
+}
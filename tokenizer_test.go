@@ -0,0 +1,80 @@
+package pyast
+
+import (
+	"reflect"
+	"testing"
+)
+
+func tokenValues(tokens []token, kinds ...tokenType) []string {
+	result := []string{}
+	for _, tok := range tokens {
+		for _, kind := range kinds {
+			if tok.kind == kind {
+				result = append(result, tok.value)
+			}
+		}
+	}
+	return result
+}
+
+func TestTokenizeStrings(t *testing.T) {
+	tokens := tokenize(`x = rb'\'' + f"{a['#']!r:>{width}}" + """
+import foo
+""" # trailing`)
+	expected := []string{`rb'\''`, `f"{a['#']!r:>{width}}"`, "\"\"\"\nimport foo\n\"\"\""}
+	if actual := tokenValues(tokens, tokenString); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Found unexpected strings:\n%q", actual)
+	}
+	if actual := tokenValues(tokens, tokenComment); !reflect.DeepEqual(actual, []string{"# trailing"}) {
+		t.Fatalf("Found unexpected comments:\n%q", actual)
+	}
+}
+
+func TestTokenizeNestedFString(t *testing.T) {
+	// python 3.12 allows the same quote to be reused inside a replacement field
+	tokens := tokenize(`f"{"}"}" ; import foo`)
+	if actual := tokenValues(tokens, tokenString); !reflect.DeepEqual(actual, []string{`f"{"}"}"`}) {
+		t.Fatalf("Found unexpected strings:\n%q", actual)
+	}
+	if actual := tokenValues(tokens, tokenName); !reflect.DeepEqual(actual, []string{"import", "foo"}) {
+		t.Fatalf("Found unexpected names:\n%q", actual)
+	}
+}
+
+func TestTokenizeLines(t *testing.T) {
+	tokens := tokenize("if x:\n    y = (1,\n         2)\n\n# comment\nz = a \\\n    + b\n")
+	var kinds []string
+	for _, tok := range tokens {
+		if tok.kind != tokenName && tok.kind != tokenNumber && tok.kind != tokenOp {
+			kinds = append(kinds, tok.kind.String())
+		}
+	}
+	expected := []string{"NEWLINE", "INDENT", "NL", "NEWLINE", "NL", "COMMENT", "NL", "DEDENT", "NEWLINE", "ENDMARKER"}
+	if !reflect.DeepEqual(kinds, expected) {
+		t.Fatalf("Found unexpected token kinds:\n%q", kinds)
+	}
+	for _, tok := range tokens {
+		if tok.value == "z" && (tok.line != 6 || tok.column != 0) {
+			t.Fatalf("z is at %v:%v", tok.line, tok.column)
+		}
+		if tok.value == "b" && tok.line != 7 {
+			t.Fatalf("b is on line %v", tok.line)
+		}
+	}
+}
+
+func TestDecodeSource(t *testing.T) {
+	source, err := decodeSource([]byte("# -*- coding: latin-1 -*-\nname = '\xe9'\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source != "# -*- coding: latin-1 -*-\nname = 'é'\n" {
+		t.Fatalf("Decoded version was:\n%q\n, which is wrong", source)
+	}
+	if source, _ := decodeSource([]byte("\xef\xbb\xbfimport foo")); source != "import foo" {
+		t.Fatalf("Decoded version was:\n%q\n, which is wrong", source)
+	}
+	if _, err := decodeSource([]byte("#!/usr/bin/python\n# coding=not-a-real-encoding\n")); err == nil {
+		t.Fatal("expected an error for an unknown encoding")
+	}
+}
@@ -0,0 +1,34 @@
+package pyast
+
+import (
+	"os"
+	"time"
+)
+
+// Versioner identifies the version of a file, given its path and content.
+// The imports cached for a module are reused while its version, class and
+// the parsing options are unchanged.
+type Versioner func(path string, content []byte) (string, error)
+
+// ContentVersioner uses a hash of the content, so cached imports remain
+// valid after a checkout changes modification times, and can be shared
+// between checkouts and machines. It is the default.
+func ContentVersioner(path string, content []byte) (string, error) {
+	return contentHash(content), nil
+}
+
+// MtimeVersioner uses the path and modification time of the file.
+func MtimeVersioner(path string, content []byte) (string, error) {
+	fileinfo, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return path + "@" + fileinfo.ModTime().Format(time.RFC3339Nano), nil
+}
+
+func (o BuildTreesOptions) versioner() Versioner {
+	if o.Versioner == nil {
+		return ContentVersioner
+	}
+	return o.Versioner
+}
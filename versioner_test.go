@@ -0,0 +1,65 @@
+package pyast
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	file "github.com/nicois/file"
+)
+
+func TestVersioners(t *testing.T) {
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+	a := filepath.Join(dir, "a.py")
+	b := filepath.Join(dir, "b.py")
+	writeFile(t, a, "import os\n")
+	writeFile(t, b, "import os\n")
+
+	versionA, _ := ContentVersioner(a, []byte("import os\n"))
+	versionB, _ := ContentVersioner(b, []byte("import os\n"))
+	if versionA != versionB {
+		t.Fatalf("Found unexpected versions for the same content: %v %v", versionA, versionB)
+	}
+	if changed, _ := ContentVersioner(a, []byte("import sys\n")); changed == versionA {
+		t.Fatalf("Found unexpected version for changed content: %v", changed)
+	}
+
+	before, err := MtimeVersioner(a, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(a, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := MtimeVersioner(a, nil); after == before {
+		t.Fatalf("Found unexpected version after touching the file: %v", after)
+	}
+	if _, err := MtimeVersioner(filepath.Join(dir, "missing.py"), nil); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
+
+func TestBuildTreesUsesVersioner(t *testing.T) {
+	root, _ := filepath.EvalSymlinks(t.TempDir())
+	writeFile(t, filepath.Join(root, "app/__init__.py"), "")
+	writeFile(t, filepath.Join(root, "app/models.py"), "")
+	versioned := file.CreatePaths()
+	var mutex sync.Mutex
+	versioner := func(path string, content []byte) (string, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		versioned.Add(path)
+		return ContentVersioner(path, content)
+	}
+	if _, err := BuildTreesWithOptions(context.Background(), file.CreatePaths(root), BuildTreesOptions{Versioner: versioner}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := file.CreatePaths(filepath.Join(root, "app/__init__.py"), filepath.Join(root, "app/models.py")); !reflect.DeepEqual(versioned, expected) {
+		t.Fatalf("Found unexpected versioned files: %v", versioned)
+	}
+}
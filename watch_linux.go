@@ -0,0 +1,211 @@
+//go:build linux
+
+package pyast
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+	"unsafe"
+
+	file "github.com/nicois/file"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const watchMask = unix.IN_CLOSE_WRITE | unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_ONLYDIR
+
+// settleTime is how long to wait for further changes before rescanning,
+// so a burst of changes (eg a git checkout) is handled in one go. Changes
+// are rescanned this long after the first of them, even if more follow.
+const settleTime = time.Second / 10
+
+type watcher struct {
+	server  *Server
+	fd      int
+	dirs    map[int][]string // maps watch descriptors to the paths their directory was reached through
+	pending file.Paths       // python files to rescan
+	removed file.Paths       // directories which have gone away
+
+	// overflowed is set when the kernel dropped events, so that
+	// everything must be rescanned
+	overflowed bool
+}
+
+// newWatcher starts watching every directory beneath the server's roots.
+func newWatcher(s *Server) (*watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	w := &watcher{server: s, fd: fd, dirs: make(map[int][]string), pending: file.CreatePaths(), removed: file.CreatePaths()}
+	s.mutex.RLock()
+	for _, tree := range *s.trees {
+		w.addRecursively(tree.root, false)
+	}
+	s.mutex.RUnlock()
+	return w, nil
+}
+
+// run rescans python files whenever they change, until ctx is cancelled.
+func (w *watcher) run(ctx context.Context) error {
+	defer unix.Close(w.fd)
+	fd := w.fd
+	s := w.server
+
+	buffer := make([]byte, 64*1024)
+	var firstPending time.Time // zero unless something is waiting to be rescanned
+	for ctx.Err() == nil {
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		if _, err := unix.Poll(fds, int(settleTime/time.Millisecond)); err != nil && err != unix.EINTR {
+			return err
+		}
+		n, err := unix.Read(fd, buffer)
+		if err != nil && err != unix.EAGAIN && err != unix.EINTR {
+			return err
+		}
+		if n > 0 {
+			w.handle(buffer[:n])
+			if firstPending.IsZero() && (w.overflowed || len(w.pending) > 0 || len(w.removed) > 0) {
+				firstPending = time.Now()
+			}
+		}
+		if firstPending.IsZero() || time.Since(firstPending) < settleTime {
+			continue
+		}
+		firstPending = time.Time{}
+		if w.overflowed {
+			w.rescanAll(ctx)
+			continue
+		}
+		for dir := range w.removed {
+			if err := s.rescanBelow(dir); err != nil {
+				log.Warn(err)
+			}
+		}
+		if err := s.Rescan(w.pending); err != nil {
+			log.Warn(err)
+		}
+		log.Debugf("Rescanned %v files and %v directories", len(w.pending), len(w.removed))
+		w.pending = file.CreatePaths()
+		w.removed = file.CreatePaths()
+	}
+	return nil
+}
+
+// rescanAll watches any directories which were missed, and brings every
+// tree up to date, after events were dropped.
+func (w *watcher) rescanAll(ctx context.Context) {
+	s := w.server
+	s.mutex.RLock()
+	for _, tree := range *s.trees {
+		w.addRecursively(tree.root, false)
+	}
+	s.mutex.RUnlock()
+	s.mutex.Lock()
+	err := s.trees.Refresh(ctx)
+	s.mutex.Unlock()
+	if err != nil {
+		log.Warn(err)
+	}
+	log.Debugf("Rescanned every root")
+	w.overflowed = false
+	w.pending = file.CreatePaths()
+	w.removed = file.CreatePaths()
+}
+
+func ignoredDirectory(name string) bool {
+	return strings.HasPrefix(name, ".") || name == "__pycache__"
+}
+
+// addRecursively watches dir and everything beneath it, following symlinks
+// as the trees are built. If the directory is new, the python files within
+// it are also queued for scanning.
+func (w *watcher) addRecursively(dir string, isNew bool) {
+	walkFollowingSymlinks(dir, func(path string, canonical string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != dir && ignoredDirectory(d.Name()) {
+				return fs.SkipDir
+			}
+			// a directory reached through several paths has one watch
+			wd, err := unix.InotifyAddWatch(w.fd, path, watchMask)
+			if err != nil {
+				log.Warnf("Could not watch %v: %v", path, err)
+				return fs.SkipDir
+			}
+			w.dirs[wd] = appendNew(w.dirs[wd], path)
+			return nil
+		}
+		if isNew && strings.HasSuffix(path, ".py") {
+			w.pending.Add(path)
+		}
+		return nil
+	})
+}
+
+func (w *watcher) handle(events []byte) {
+	for offset := 0; offset+unix.SizeofInotifyEvent <= len(events); {
+		event := (*unix.InotifyEvent)(unsafe.Pointer(&events[offset]))
+		nameBytes := events[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(event.Len)]
+		offset += unix.SizeofInotifyEvent + int(event.Len)
+		name := strings.TrimRight(string(nameBytes), "\x00")
+
+		if event.Mask&unix.IN_Q_OVERFLOW != 0 {
+			// which has no watch descriptor
+			log.Warn("Too many changes to follow them all; rescanning every root")
+			w.overflowed = true
+			continue
+		}
+		dirs, ok := w.dirs[int(event.Wd)]
+		if !ok {
+			continue
+		}
+		if event.Mask&(unix.IN_IGNORED|unix.IN_DELETE_SELF) != 0 {
+			delete(w.dirs, int(event.Wd))
+			continue
+		}
+		for _, dir := range dirs {
+			w.handleEntry(filepath.Join(dir, name), event.Mask)
+		}
+	}
+}
+
+// handleEntry queues what needs rescanning after an event for path, an
+// entry of a watched directory.
+func (w *watcher) handleEntry(path string, mask uint32) {
+	if strings.HasSuffix(path, ".py") && mask&unix.IN_ISDIR == 0 {
+		w.pending.Add(path)
+		return
+	}
+	if ignoredDirectory(filepath.Base(path)) {
+		return
+	}
+	switch {
+	case mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+		// symlinks to directories are not flagged as directories
+		if mask&unix.IN_ISDIR != 0 || file.DirExists(path) {
+			w.addRecursively(path, true)
+		}
+	case mask&(unix.IN_DELETE|unix.IN_MOVED_FROM) != 0:
+		if mask&unix.IN_ISDIR != 0 || w.watching(path) {
+			w.removed.Add(path)
+		}
+	}
+}
+
+// watching reports if the directory at path was being watched.
+func (w *watcher) watching(path string) bool {
+	for _, dirs := range w.dirs {
+		for _, dir := range dirs {
+			if dir == path {
+				return true
+			}
+		}
+	}
+	return false
+}
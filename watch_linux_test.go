@@ -0,0 +1,84 @@
+//go:build linux
+
+package pyast
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+	"unsafe"
+
+	file "github.com/nicois/file"
+	"golang.org/x/sys/unix"
+)
+
+func TestWatcherRescansAfterOverflow(t *testing.T) {
+	root, _ := filepath.EvalSymlinks(t.TempDir())
+	writeFile(t, filepath.Join(root, "app/__init__.py"), "")
+	writeFile(t, filepath.Join(root, "app/b.py"), "")
+	ctx := context.Background()
+	server, err := NewServer(ctx, file.CreatePaths(root), BuildTreesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher, err := newWatcher(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(watcher.fd)
+
+	// changes whose events were dropped
+	b := filepath.Join(root, "app/b.py")
+	c := filepath.Join(root, "app/new/c.py")
+	writeFile(t, filepath.Join(root, "app/new/__init__.py"), "")
+	writeFile(t, c, "from app import b\n")
+
+	event := unix.InotifyEvent{Wd: -1, Mask: unix.IN_Q_OVERFLOW}
+	watcher.handle(unsafe.Slice((*byte)(unsafe.Pointer(&event)), unix.SizeofInotifyEvent))
+	if !watcher.overflowed {
+		t.Fatal("the overflow was not noticed")
+	}
+	watcher.rescanAll(ctx)
+	if response := server.Handle(Request{Command: "rdeps", Paths: []string{b}}); !reflect.DeepEqual(response.Paths, []string{b, c}) {
+		t.Fatalf("Found unexpected paths: %+v", response)
+	}
+	if !watcher.watching(filepath.Join(root, "app/new")) {
+		t.Fatal("the new directory is not watched")
+	}
+}
+
+func TestWatcherRescansWhileChangesContinue(t *testing.T) {
+	root, _ := filepath.EvalSymlinks(t.TempDir())
+	writeFile(t, filepath.Join(root, "app/__init__.py"), "")
+	writeFile(t, filepath.Join(root, "app/b.py"), "")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server, err := NewServer(ctx, file.CreatePaths(root), BuildTreesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher, err := newWatcher(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go watcher.run(ctx)
+
+	b := filepath.Join(root, "app/b.py")
+	c := filepath.Join(root, "app/c.py")
+	writeFile(t, c, "from app import b\n")
+	// changes arriving more often than settleTime
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		writeFile(t, filepath.Join(root, "app/busy.py"), "# "+time.Now().String()+"\n")
+		response := server.Handle(Request{Command: "rdeps", Paths: []string{b}})
+		if reflect.DeepEqual(response.Paths, []string{b, c}) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the server did not notice the new file: %+v", response)
+		}
+		time.Sleep(settleTime / 5)
+	}
+}
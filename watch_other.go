@@ -0,0 +1,20 @@
+//go:build !linux
+
+package pyast
+
+import (
+	"context"
+	"errors"
+)
+
+// watcher is only implemented on linux; elsewhere, clients must ask the
+// server to rescan files which have changed.
+type watcher struct{}
+
+func newWatcher(s *Server) (*watcher, error) {
+	return nil, errors.New("watching for changes is only supported on linux")
+}
+
+func (w *watcher) run(ctx context.Context) error {
+	return nil
+}